@@ -0,0 +1,112 @@
+// Package container removes and re-applies the layers MHF client files are
+// often wrapped in: ECD encryption and JKR (JPK) compression. A file taken
+// from the client dat folder is typically an ECD file holding a JKR file
+// holding the actual data.
+package container
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrFormat is a layer whose header or data cannot be decoded.
+	ErrFormat = errors.New("malformed container")
+	// ErrChecksum is an ECD file whose decrypted data does not match the
+	// CRC32 in its header.
+	ErrChecksum = errors.New("checksum mismatch")
+)
+
+// MaxSize is the largest decrypted or decompressed size accepted, so that a
+// corrupt header cannot make Unwrap allocate gigabytes.
+const MaxSize = 64 << 20
+
+// maxLayers is the number of nested layers Unwrap removes before giving up.
+const maxLayers = 8
+
+type Kind string
+
+const (
+	ECD Kind = "ecd"
+	JKR Kind = "jkr"
+)
+
+// Layer is one ECD or JKR wrapper and the header values needed to wrap the
+// data the same way again.
+type Layer struct {
+	Kind Kind
+	// Key is the ECD key set, Unk06 the header bytes after it.
+	Key   uint16
+	Unk06 uint16
+	// Type is the JKR compression, Version the header bytes before it.
+	Type    JKRType
+	Version uint16
+}
+
+func (l Layer) String() string {
+	if l.Kind == ECD {
+		return fmt.Sprintf("ECD (key %d)", l.Key)
+	}
+	return fmt.Sprintf("JKR (%s)", l.Type)
+}
+
+// Describe lists layers outermost first, e.g. "ECD (key 4) > JKR (HFI)".
+func Describe(layers []Layer) string {
+	if len(layers) == 0 {
+		return "none"
+	}
+	names := make([]string, len(layers))
+	for i, l := range layers {
+		names[i] = l.String()
+	}
+	return strings.Join(names, " > ")
+}
+
+// Unwrap removes every ECD and JKR layer around data, outermost first, and
+// returns the inner data with the layers it removed. Data without a known
+// magic is returned as is with no layers.
+func Unwrap(data []byte) ([]byte, []Layer, error) {
+	var layers []Layer
+	for {
+		var layer Layer
+		var err error
+		switch {
+		case isECD(data):
+			layer, data, err = decryptECD(data)
+		case isJKR(data):
+			layer, data, err = decompressJKR(data)
+		default:
+			return data, layers, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("layer %d: %w", len(layers)+1, err)
+		}
+		layers = append(layers, layer)
+		if len(layers) > maxLayers {
+			return nil, nil, fmt.Errorf("%w: more than %d nested layers", ErrFormat, maxLayers)
+		}
+	}
+}
+
+// Wrap applies layers to data, innermost (last) first, so that Wrap undoes
+// Unwrap. JKR data is compressed again with the same type, which does not
+// necessarily give back the original bytes but decompresses to the same
+// data.
+func Wrap(data []byte, layers []Layer) ([]byte, error) {
+	for i := len(layers) - 1; i >= 0; i-- {
+		var err error
+		switch l := layers[i]; l.Kind {
+		case ECD:
+			data, err = encryptECD(data, l)
+		case JKR:
+			data, err = compressJKR(data, l)
+		default:
+			err = fmt.Errorf("unknown layer kind '%s'", l.Kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
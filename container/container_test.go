@@ -0,0 +1,193 @@
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func testData() map[string][]byte {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	r.Read(random)
+	text := bytes.Repeat([]byte("メゼポルタ広場 Mezeporta Square, 0123456789\x00\x00\x00\x00"), 300)
+	long := append(bytes.Repeat([]byte{0xAB}, 1000), random[:300]...)
+	return map[string][]byte{"empty": {}, "one byte": {7}, "random": random, "text": text, "long runs": long}
+}
+
+func TestRoundTrip(t *testing.T) {
+	layers := map[string][]Layer{
+		"ecd":         {{Kind: ECD, Key: 4}},
+		"jkr raw":     {{Kind: JKR, Type: JKRRaw}},
+		"jkr hfirw":   {{Kind: JKR, Type: JKRHFIRaw}},
+		"jkr lz":      {{Kind: JKR, Type: JKRLZ}},
+		"jkr hfi":     {{Kind: JKR, Type: JKRHFI}},
+		"ecd and jkr": {{Kind: ECD, Key: 0, Unk06: 0x1234}, {Kind: JKR, Type: JKRHFI, Version: jkrVersion}},
+	}
+	for key := range ecdKeys {
+		layers[fmt.Sprintf("ecd key %d", key)] = []Layer{{Kind: ECD, Key: uint16(key)}}
+	}
+	for lname, want := range layers {
+		for dname, data := range testData() {
+			t.Run(lname+"/"+dname, func(t *testing.T) {
+				wrapped, err := Wrap(data, want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, gotLayers, err := Unwrap(wrapped)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Errorf("Unwrap(Wrap(data)) differs from data (%d bytes, want %d)", len(got), len(data))
+				}
+				for i := range want {
+					if want[i].Kind == JKR && want[i].Version == 0 {
+						want[i].Version = jkrVersion
+					}
+				}
+				if !reflect.DeepEqual(gotLayers, want) {
+					t.Errorf("layers = %v, want %v", gotLayers, want)
+				}
+			})
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	data := testData()["text"]
+	for _, typ := range []JKRType{JKRLZ, JKRHFI} {
+		out, err := compressJKR(data, Layer{Kind: JKR, Type: typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) > len(data)/4 {
+			t.Errorf("%v compressed %d repetitive bytes to %d", typ, len(data), len(out))
+		}
+	}
+}
+
+// decodeLZ is checked against a stream assembled by hand, so it does not
+// only agree with encodeLZ
+func TestDecodeLZ(t *testing.T) {
+	// Flags 0 0 0 (A B C), 1 0 11 (copy 6 from 3 back), 0 (X)
+	stream := []byte{0b00010110, 'A', 'B', 'C', 0x02, 'X'}
+	out := make([]byte, 10)
+	if err := decodeLZ(&plainSource{data: stream}, out); err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "ABCABCABCX" {
+		t.Errorf("decodeLZ() = %q", out)
+	}
+
+	// Copy from before the start
+	if err := decodeLZ(&plainSource{data: []byte{0b10110000, 0x05}}, make([]byte, 6)); !errors.Is(err, ErrFormat) {
+		t.Errorf("decodeLZ() with a bad offset error = %v", err)
+	}
+	// Stream ends before the size is reached
+	if err := decodeLZ(&plainSource{data: stream}, make([]byte, 11)); !errors.Is(err, ErrFormat) {
+		t.Errorf("decodeLZ() of a short stream error = %v", err)
+	}
+}
+
+// The ECD blob was encrypted with key set 4 by a separate implementation of
+// the client's decryption, so Wrap and Unwrap do not only agree with each
+// other
+func TestECDKnownAnswer(t *testing.T) {
+	plain := []byte("mhfjmp.bin\x00")
+	blob := []byte{
+		0x65, 0x63, 0x64, 0x1A, 0x04, 0x00, 0x00, 0x00, // magic, key set 4, Unk06
+		0x0B, 0x00, 0x00, 0x00, 0x85, 0x52, 0x40, 0xEE, // size, CRC32
+		0x47, 0x81, 0x5E, 0x09, 0xFF, 0x82, 0xBF, 0x7D, 0x4D, 0xAC, 0x2F,
+	}
+	got, layers, err := Unwrap(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) || !reflect.DeepEqual(layers, []Layer{{Kind: ECD, Key: 4}}) {
+		t.Errorf("Unwrap() = %q, %v", got, layers)
+	}
+	if wrapped, err := Wrap(plain, layers); err != nil || !bytes.Equal(wrapped, blob) {
+		t.Errorf("Wrap() = % X, %v, want % X", wrapped, err, blob)
+	}
+}
+
+// A JKR HFI file assembled by hand: a Huffman tree with three leaves coding
+// an LZ stream of one literal and one copy
+func TestJKRKnownAnswer(t *testing.T) {
+	blob := []byte{
+		0x4A, 0x4B, 0x52, 0x1A, 0x08, 0x01, 0x04, 0x00, // magic, version 0x108, HFI
+		0x10, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, // data offset, size 7
+		// Root 0x101: 0 is node 0x100, 1 is 0x58; node 0x100: 0 is 'A', 1 is 0x00
+		0x01, 0x01, 0x41, 0x00, 0x00, 0x00, 0x00, 0x01, 0x58, 0x00,
+		// 1 00 01: the LZ stream 0x58 'A' 0x00, which is flags 0 (A) and
+		// 1 0 11 (copy 6 from 1 back)
+		0b10001000,
+	}
+	got, layers, err := Unwrap(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "AAAAAAA" || !reflect.DeepEqual(layers, []Layer{{Kind: JKR, Type: JKRHFI, Version: jkrVersion}}) {
+		t.Errorf("Unwrap() = %q, %v", got, layers)
+	}
+}
+
+func TestUnwrapErrors(t *testing.T) {
+	ecd, _ := Wrap([]byte("some data"), []Layer{{Kind: ECD, Key: 1}})
+	jkr, _ := Wrap([]byte("some data"), []Layer{{Kind: JKR, Type: JKRLZ}})
+	tests := []struct {
+		name   string
+		data   []byte
+		modify func(b []byte)
+		want   error
+	}{
+		{"ecd checksum", ecd, func(b []byte) { b[20] ^= 1 }, ErrChecksum},
+		{"ecd key set", ecd, func(b []byte) { b[4] = 9 }, ErrFormat},
+		{"ecd size", ecd, func(b []byte) { binary.LittleEndian.PutUint32(b[8:], 100) }, ErrFormat},
+		{"jkr type", jkr, func(b []byte) { b[6] = 1 }, ErrFormat},
+		{"jkr offset", jkr, func(b []byte) { binary.LittleEndian.PutUint32(b[8:], 0xFFFF) }, ErrFormat},
+		{"jkr size", jkr, func(b []byte) { binary.LittleEndian.PutUint32(b[12:], 0xFFFFFFFF) }, ErrFormat},
+		{"jkr truncated", jkr, func(b []byte) { binary.LittleEndian.PutUint32(b[12:], 100) }, ErrFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Clone(tt.data)
+			tt.modify(data)
+			if _, _, err := Unwrap(data); !errors.Is(err, tt.want) {
+				t.Errorf("Unwrap() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	plain := []byte{1, 2, 3}
+	if got, layers, err := Unwrap(plain); err != nil || len(layers) != 0 || !bytes.Equal(got, plain) {
+		t.Errorf("Unwrap() of plain data = %v, %v, %v", got, layers, err)
+	}
+}
+
+func FuzzUnwrap(f *testing.F) {
+	for _, layers := range [][]Layer{{{Kind: ECD}}, {{Kind: JKR, Type: JKRLZ}}, {{Kind: JKR, Type: JKRHFI}}, {{Kind: JKR, Type: JKRHFIRaw}}} {
+		data, _ := Wrap([]byte("ABCABCABCABCABC 0123"), layers)
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out, layers, err := Unwrap(data)
+		if err != nil || len(layers) == 0 {
+			return
+		}
+		// Whatever decodes must survive being wrapped again
+		again, err := Wrap(out, layers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, _, err := Unwrap(again)
+		if err != nil || !bytes.Equal(back, out) {
+			t.Fatalf("re-wrapped data does not unwrap to the same bytes: %v", err)
+		}
+	})
+}
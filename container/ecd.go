@@ -0,0 +1,116 @@
+package container
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	ecdMagic      = 0x1A646365 // "ecd\x1A"
+	ecdHeaderSize = 0x10
+)
+
+// ecdKeys are the multiplier and increment of the generator used by each
+// key set.
+var ecdKeys = [][2]uint32{
+	{0x4A4B522E, 1},
+	{0x00010DCD, 1},
+	{0x00010DCD, 1},
+	{0x00010DCD, 1},
+	{0x0019660D, 3},
+	{0x7D2B89DD, 1},
+}
+
+func isECD(data []byte) bool {
+	return len(data) >= 4 && binary.LittleEndian.Uint32(data) == ecdMagic
+}
+
+// ecdStream is the key stream of one file. It is seeded from the CRC32 of
+// the plain data stored in the header.
+type ecdStream struct {
+	key   [2]uint32
+	state uint32
+}
+
+func newECDStream(key uint16, crc uint32) (*ecdStream, error) {
+	if int(key) >= len(ecdKeys) {
+		return nil, fmt.Errorf("%w: unknown ECD key set %d", ErrFormat, key)
+	}
+	return &ecdStream{key: ecdKeys[key], state: crc<<16 | crc>>16 | 1}, nil
+}
+
+func (s *ecdStream) next() uint32 {
+	s.state = s.state*s.key[0] + s.key[1]
+	return s.state
+}
+
+// nibbles returns the two nibbles every byte is mixed with, derived from the
+// 8 nibbles of the next value of the stream.
+func (s *ecdStream) nibbles() (hi, lo uint32) {
+	pad := s.next()
+	for j := 0; j < 8; j++ {
+		hi, lo = lo, (lo^pad^hi)&0xFF
+		pad >>= 4
+	}
+	return hi & 0xF, lo & 0xF
+}
+
+func decryptECD(data []byte) (Layer, []byte, error) {
+	if len(data) < ecdHeaderSize {
+		return Layer{}, nil, fmt.Errorf("%w: ECD header is %d bytes, expected %d", ErrFormat, len(data), ecdHeaderSize)
+	}
+	layer := Layer{Kind: ECD, Key: binary.LittleEndian.Uint16(data[4:]), Unk06: binary.LittleEndian.Uint16(data[6:])}
+	size := binary.LittleEndian.Uint32(data[8:])
+	crc := binary.LittleEndian.Uint32(data[12:])
+	if size > MaxSize || int64(size) > int64(len(data)-ecdHeaderSize) {
+		return layer, nil, fmt.Errorf("%w: ECD data size %d, but only %d bytes follow the header",
+			ErrFormat, size, len(data)-ecdHeaderSize)
+	}
+	s, err := newECDStream(layer.Key, crc)
+	if err != nil {
+		return layer, nil, err
+	}
+
+	out := make([]byte, size)
+	prev := byte(s.next())
+	for i := range out {
+		hi, lo := s.nibbles()
+		c := uint32(data[ecdHeaderSize+i] ^ prev)
+		cLo, cHi := c&0xF, c>>4
+		out[i] = byte((cLo^lo)&0xF | (cLo^cHi^hi)<<4)
+		prev = out[i]
+	}
+	if got := crc32.ChecksumIEEE(out); got != crc {
+		return layer, nil, fmt.Errorf("%w: ECD data has CRC32 0x%08X, header says 0x%08X", ErrChecksum, got, crc)
+	}
+	return layer, out, nil
+}
+
+func encryptECD(data []byte, layer Layer) ([]byte, error) {
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("%w: %d bytes is too large for ECD", ErrFormat, len(data))
+	}
+	crc := crc32.ChecksumIEEE(data)
+	s, err := newECDStream(layer.Key, crc)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, ecdHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(out[0:], ecdMagic)
+	binary.LittleEndian.PutUint16(out[4:], layer.Key)
+	binary.LittleEndian.PutUint16(out[6:], layer.Unk06)
+	binary.LittleEndian.PutUint32(out[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(out[12:], crc)
+	prev := byte(s.next())
+	for i, p := range data {
+		hi, lo := s.nibbles()
+		pLo, pHi := uint32(p)&0xF, uint32(p)>>4
+		cLo := pLo ^ lo
+		cHi := (pHi ^ hi ^ cLo) & 0xF
+		out[ecdHeaderSize+i] = byte(cLo|cHi<<4) ^ prev
+		prev = p
+	}
+	return out, nil
+}
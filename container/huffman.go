@@ -0,0 +1,153 @@
+package container
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+)
+
+// The Huffman coded JKR types start with a uint16 n, followed by the tree
+// as (n-0xFF)*2 uint16 child values and then the coded bits, most
+// significant first. Values below 0x100 are leaves holding a byte; node v
+// has its 0 child at index (v-0x100)*2 and its 1 child right after it. The
+// root is n itself.
+const huffmanLeaves = 0x100
+
+type huffmanSource struct {
+	table []uint16
+	root  int
+	data  []byte
+	pos   int
+	flag  byte
+	shift int
+}
+
+func newHuffmanSource(data []byte) (*huffmanSource, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("%w: JKR Huffman table is missing", ErrFormat)
+	}
+	root := int(binary.LittleEndian.Uint16(data))
+	if root < huffmanLeaves {
+		return nil, fmt.Errorf("%w: JKR Huffman root %d is a leaf", ErrFormat, root)
+	}
+	entries := (root - (huffmanLeaves - 1)) * 2
+	if 2+entries*2 > len(data) {
+		return nil, fmt.Errorf("%w: JKR Huffman table of %d entries does not fit in the file", ErrFormat, entries)
+	}
+	s := &huffmanSource{table: make([]uint16, entries), root: root, data: data[2+entries*2:]}
+	for i := range s.table {
+		s.table[i] = binary.LittleEndian.Uint16(data[2+i*2:])
+	}
+	return s, nil
+}
+
+func (s *huffmanSource) readByte() (byte, error) {
+	v := s.root
+	// Each step goes one level down, a valid tree is never deeper than its
+	// number of nodes
+	for depth := 0; v >= huffmanLeaves; depth++ {
+		if depth > len(s.table) {
+			return 0, fmt.Errorf("%w: JKR Huffman tree has a loop", ErrFormat)
+		}
+		s.shift--
+		if s.shift < 0 {
+			if s.pos >= len(s.data) {
+				return 0, fmt.Errorf("%w: JKR data ends early", ErrFormat)
+			}
+			s.shift = 7
+			s.flag = s.data[s.pos]
+			s.pos++
+		}
+		i := (v-huffmanLeaves)*2 + int(s.flag>>s.shift)&1
+		if i >= len(s.table) {
+			return 0, fmt.Errorf("%w: JKR Huffman node %d is outside the table", ErrFormat, v)
+		}
+		v = int(s.table[i])
+	}
+	return byte(v), nil
+}
+
+type huffmanNode struct {
+	weight int
+	value  int // byte for a leaf, node number otherwise
+	order  int // creation order, keeps the tree deterministic
+}
+
+type huffmanHeap []huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].weight != h[j].weight {
+		return h[i].weight < h[j].weight
+	}
+	return h[i].order < h[j].order
+}
+func (h huffmanHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x any)   { *h = append(*h, x.(huffmanNode)) }
+func (h *huffmanHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// encodeHuffman codes data for huffmanSource. The tree always holds all 256
+// byte values, so its root is 0x1FE.
+func encodeHuffman(data []byte) []byte {
+	var counts [huffmanLeaves]int
+	for _, b := range data {
+		counts[b]++
+	}
+	h := make(huffmanHeap, huffmanLeaves)
+	for i := range h {
+		h[i] = huffmanNode{weight: counts[i], value: i, order: i}
+	}
+	heap.Init(&h)
+
+	table := make([]uint16, (huffmanLeaves-1)*2)
+	next := huffmanLeaves
+	for h.Len() > 1 {
+		zero := heap.Pop(&h).(huffmanNode)
+		one := heap.Pop(&h).(huffmanNode)
+		table[(next-huffmanLeaves)*2] = uint16(zero.value)
+		table[(next-huffmanLeaves)*2+1] = uint16(one.value)
+		heap.Push(&h, huffmanNode{weight: zero.weight + one.weight, value: next, order: next})
+		next++
+	}
+	root := next - 1
+
+	// The code of each byte, found by walking down from the root
+	codes := make([][]byte, huffmanLeaves)
+	var walk func(v int, code []byte)
+	walk = func(v int, code []byte) {
+		if v < huffmanLeaves {
+			codes[v] = append([]byte(nil), code...)
+			return
+		}
+		walk(int(table[(v-huffmanLeaves)*2]), append(code, 0))
+		walk(int(table[(v-huffmanLeaves)*2+1]), append(code, 1))
+	}
+	walk(root, nil)
+
+	out := make([]byte, 2+len(table)*2)
+	binary.LittleEndian.PutUint16(out, uint16(root))
+	for i, v := range table {
+		binary.LittleEndian.PutUint16(out[2+i*2:], v)
+	}
+	var flag byte
+	shift := 8
+	for _, b := range data {
+		for _, bit := range codes[b] {
+			shift--
+			flag |= bit << shift
+			if shift == 0 {
+				out = append(out, flag)
+				flag, shift = 0, 8
+			}
+		}
+	}
+	if shift < 8 {
+		out = append(out, flag)
+	}
+	return out
+}
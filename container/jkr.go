@@ -0,0 +1,368 @@
+package container
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	jkrMagic      = 0x1A524B4A // "JKR\x1A"
+	jkrHeaderSize = 0x10
+	jkrVersion    = 0x108
+)
+
+// JKRType is the compression of a JKR file.
+type JKRType uint16
+
+const (
+	JKRRaw    JKRType = 0 // stored
+	JKRHFIRaw JKRType = 2 // Huffman coded
+	JKRLZ     JKRType = 3 // LZ compressed
+	JKRHFI    JKRType = 4 // LZ compressed, then Huffman coded
+)
+
+func (t JKRType) String() string {
+	switch t {
+	case JKRRaw:
+		return "raw"
+	case JKRHFIRaw:
+		return "HFIRW"
+	case JKRLZ:
+		return "LZ"
+	case JKRHFI:
+		return "HFI"
+	}
+	return fmt.Sprintf("type %d", uint16(t))
+}
+
+func isJKR(data []byte) bool {
+	return len(data) >= 4 && binary.LittleEndian.Uint32(data) == jkrMagic
+}
+
+func decompressJKR(data []byte) (Layer, []byte, error) {
+	if len(data) < jkrHeaderSize {
+		return Layer{}, nil, fmt.Errorf("%w: JKR header is %d bytes, expected %d", ErrFormat, len(data), jkrHeaderSize)
+	}
+	layer := Layer{Kind: JKR, Version: binary.LittleEndian.Uint16(data[4:]), Type: JKRType(binary.LittleEndian.Uint16(data[6:]))}
+	start := binary.LittleEndian.Uint32(data[8:])
+	size := binary.LittleEndian.Uint32(data[12:])
+	if start < jkrHeaderSize || int64(start) > int64(len(data)) {
+		return layer, nil, fmt.Errorf("%w: JKR data offset 0x%X is outside the file", ErrFormat, start)
+	}
+	if size > MaxSize {
+		return layer, nil, fmt.Errorf("%w: JKR size %d is larger than %d", ErrFormat, size, MaxSize)
+	}
+
+	var src byteSource = &plainSource{data: data[start:]}
+	if layer.Type == JKRHFIRaw || layer.Type == JKRHFI {
+		h, err := newHuffmanSource(data[start:])
+		if err != nil {
+			return layer, nil, err
+		}
+		src = h
+	}
+
+	out := make([]byte, size)
+	var err error
+	switch layer.Type {
+	case JKRRaw, JKRHFIRaw:
+		for i := range out {
+			if out[i], err = src.readByte(); err != nil {
+				break
+			}
+		}
+	case JKRLZ, JKRHFI:
+		err = decodeLZ(src, out)
+	default:
+		err = fmt.Errorf("%w: unknown JKR type %d", ErrFormat, layer.Type)
+	}
+	if err != nil {
+		return layer, nil, err
+	}
+	return layer, out, nil
+}
+
+func compressJKR(data []byte, layer Layer) ([]byte, error) {
+	var body []byte
+	switch layer.Type {
+	case JKRRaw:
+		body = data
+	case JKRHFIRaw:
+		body = encodeHuffman(data)
+	case JKRLZ:
+		body = encodeLZ(data)
+	case JKRHFI:
+		body = encodeHuffman(encodeLZ(data))
+	default:
+		return nil, fmt.Errorf("%w: unknown JKR type %d", ErrFormat, layer.Type)
+	}
+	version := layer.Version
+	if version == 0 {
+		version = jkrVersion
+	}
+	out := make([]byte, jkrHeaderSize, jkrHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(out[0:], jkrMagic)
+	binary.LittleEndian.PutUint16(out[4:], version)
+	binary.LittleEndian.PutUint16(out[6:], uint16(layer.Type))
+	binary.LittleEndian.PutUint32(out[8:], jkrHeaderSize)
+	binary.LittleEndian.PutUint32(out[12:], uint32(len(data)))
+	return append(out, body...), nil
+}
+
+// byteSource is where the LZ decoder reads its bytes from: the data itself,
+// or a Huffman decoder over it.
+type byteSource interface {
+	readByte() (byte, error)
+}
+
+type plainSource struct {
+	data []byte
+	pos  int
+}
+
+func (s *plainSource) readByte() (byte, error) {
+	if s.pos >= len(s.data) {
+		return 0, fmt.Errorf("%w: JKR data ends early", ErrFormat)
+	}
+	s.pos++
+	return s.data[s.pos-1], nil
+}
+
+// The LZ stream mixes literal bytes with flag bytes whose bits, most
+// significant first, select how the following bytes are read:
+//
+//	0                   literal byte
+//	1 0 ll  off         copy ll+3 bytes from off+1 back (off is 1 byte)
+//	1 1 hi lo           copy len+2 bytes, len = hi>>5 (1-7), off = hi&0x1F<<8|lo
+//	1 1 hi lo 0 llll    hi>>5 == 0: copy llll+10 bytes
+//	1 1 hi lo 1 n       hi>>5 == 0: copy n+26 bytes, or if n is 0xFF
+//	                    copy off+27 literal bytes
+type lzReader struct {
+	src   byteSource
+	flag  byte
+	shift int
+}
+
+func (r *lzReader) bit() (int, error) {
+	r.shift--
+	if r.shift < 0 {
+		r.shift = 7
+		var err error
+		if r.flag, err = r.src.readByte(); err != nil {
+			return 0, err
+		}
+	}
+	return int(r.flag>>r.shift) & 1, nil
+}
+
+func (r *lzReader) bits(n int) (int, error) {
+	v := 0
+	for i := 0; i < n; i++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	return v, nil
+}
+
+func decodeLZ(src byteSource, out []byte) error {
+	r := &lzReader{src: src}
+	pos := 0
+	copyBack := func(off, n int) error {
+		if off+1 > pos {
+			return fmt.Errorf("%w: JKR copy from %d bytes back at byte %d", ErrFormat, off+1, pos)
+		}
+		if n > len(out)-pos {
+			return fmt.Errorf("%w: JKR copy of %d bytes at byte %d runs past the size %d", ErrFormat, n, pos, len(out))
+		}
+		for i := 0; i < n; i++ {
+			out[pos] = out[pos-off-1]
+			pos++
+		}
+		return nil
+	}
+
+	for pos < len(out) {
+		b, err := r.bit()
+		if err != nil {
+			return err
+		}
+		if b == 0 {
+			if out[pos], err = src.readByte(); err != nil {
+				return err
+			}
+			pos++
+			continue
+		}
+		if b, err = r.bit(); err != nil {
+			return err
+		}
+		if b == 0 {
+			n, err := r.bits(2)
+			if err != nil {
+				return err
+			}
+			off, err := src.readByte()
+			if err != nil {
+				return err
+			}
+			if err := copyBack(int(off), n+3); err != nil {
+				return err
+			}
+			continue
+		}
+
+		hi, err := src.readByte()
+		if err != nil {
+			return err
+		}
+		lo, err := src.readByte()
+		if err != nil {
+			return err
+		}
+		off := int(hi&0x1F)<<8 | int(lo)
+		if n := int(hi >> 5); n != 0 {
+			if err := copyBack(off, n+2); err != nil {
+				return err
+			}
+			continue
+		}
+		if b, err = r.bit(); err != nil {
+			return err
+		}
+		if b == 0 {
+			n, err := r.bits(4)
+			if err != nil {
+				return err
+			}
+			if err := copyBack(off, n+10); err != nil {
+				return err
+			}
+			continue
+		}
+		n, err := src.readByte()
+		if err != nil {
+			return err
+		}
+		if n != 0xFF {
+			if err := copyBack(off, int(n)+0x1A); err != nil {
+				return err
+			}
+			continue
+		}
+		if off+0x1B > len(out)-pos {
+			return fmt.Errorf("%w: JKR literal run of %d bytes at byte %d runs past the size %d", ErrFormat, off+0x1B, pos, len(out))
+		}
+		for i := 0; i < off+0x1B; i++ {
+			if out[pos], err = src.readByte(); err != nil {
+				return err
+			}
+			pos++
+		}
+	}
+	return nil
+}
+
+const (
+	lzWindow    = 0x2000
+	lzMaxLength = 0xFE + 0x1A
+	lzMinLength = 3
+	lzChain     = 64
+)
+
+type lzWriter struct {
+	out   []byte
+	flag  int // index of the flag byte being filled
+	shift int
+}
+
+func (w *lzWriter) bit(b int) {
+	w.shift--
+	if w.shift < 0 {
+		w.shift = 7
+		w.flag = len(w.out)
+		w.out = append(w.out, 0)
+	}
+	w.out[w.flag] |= byte(b << w.shift)
+}
+
+func (w *lzWriter) bits(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.bit(v >> i & 1)
+	}
+}
+
+// encodeLZ compresses data for decodeLZ with a greedy hash chain search.
+func encodeLZ(data []byte) []byte {
+	w := &lzWriter{}
+	head := make(map[uint32]int)
+	prev := make([]int, len(data))
+	hash := func(i int) uint32 {
+		return uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16
+	}
+	insert := func(i int) {
+		if i+lzMinLength > len(data) {
+			return
+		}
+		h := hash(i)
+		if p, ok := head[h]; ok {
+			prev[i] = p
+		} else {
+			prev[i] = -1
+		}
+		head[h] = i
+	}
+
+	for pos := 0; pos < len(data); {
+		bestLen, bestDist := 0, 0
+		if pos+lzMinLength <= len(data) {
+			p, ok := head[hash(pos)]
+			for tries := 0; ok && p >= 0 && pos-p <= lzWindow && tries < lzChain; tries++ {
+				n := 0
+				for n < lzMaxLength && pos+n < len(data) && data[p+n] == data[pos+n] {
+					n++
+				}
+				if n > bestLen {
+					bestLen, bestDist = n, pos-p
+				}
+				p = prev[p]
+			}
+		}
+		// A short far match does not fit any copy form
+		if bestLen < lzMinLength {
+			w.bit(0)
+			w.out = append(w.out, data[pos])
+			insert(pos)
+			pos++
+			continue
+		}
+
+		off := bestDist - 1
+		switch {
+		case bestLen <= 6 && off <= 0xFF:
+			w.bits(0b10, 2)
+			w.bits(bestLen-3, 2)
+			w.out = append(w.out, byte(off))
+		case bestLen <= 9:
+			w.bits(0b11, 2)
+			w.out = append(w.out, byte(bestLen-2)<<5|byte(off>>8), byte(off))
+		case bestLen <= 25:
+			w.bits(0b11, 2)
+			w.out = append(w.out, byte(off>>8), byte(off))
+			w.bit(0)
+			w.bits(bestLen-10, 4)
+		default:
+			w.bits(0b11, 2)
+			w.out = append(w.out, byte(off>>8), byte(off))
+			w.bit(1)
+			w.out = append(w.out, byte(bestLen-0x1A))
+		}
+		for i := 0; i < bestLen; i++ {
+			insert(pos + i)
+		}
+		pos += bestLen
+	}
+	return w.out
+}
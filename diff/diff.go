@@ -0,0 +1,258 @@
+// Package diff compares the menu entries and areas of two mhfjmp.bin files.
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mhfjmp-editor/jmp"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Change is one difference between two files. Index is the position of the
+// menu entry in the new file, or in the old one when it was removed, like
+// the ID column of menu_entries.csv; for areas it is the 1-based AreaIndex.
+// Field is set for changes inside an entry or area, e.g. "PosX",
+// "Entries[Index=3].Flags" or "StageIDs".
+type Change struct {
+	Op     string  `json:"op"`
+	Table  string  `json:"table"`
+	Index  int     `json:"index"`
+	JumpID *uint32 `json:"jumpId,omitempty"`
+	Field  string  `json:"field,omitempty"`
+	Old    any     `json:"old,omitempty"`
+	New    any     `json:"new,omitempty"`
+}
+
+// Result is the JSON form of a comparison.
+type Result struct {
+	Old     string   `json:"old"`
+	New     string   `json:"new"`
+	Changes []Change `json:"changes"`
+}
+
+// Files returns the changes from a to b. Menu entries are matched by JumpID
+// when it is unique in both files, otherwise by position; areas are matched
+// by position, since that is their AreaIndex.
+func Files(a, b *jmp.File) []Change {
+	changes := Menu(a.Menu, b.Menu)
+	return append(changes, Areas(a.Areas, b.Areas)...)
+}
+
+func Menu(a, b []jmp.MenuEntry) []Change {
+	changes := []Change{}
+	byJumpID := uniqueJumpIDs(a) && uniqueJumpIDs(b)
+	key := func(entry jmp.MenuEntry, i int) uint64 {
+		if byJumpID {
+			return uint64(entry.JumpID)
+		}
+		return uint64(i)
+	}
+
+	old := make(map[uint64]int, len(a))
+	for i, entry := range a {
+		old[key(entry, i)] = i
+	}
+	matched := make(map[int]bool)
+	for i, entry := range b {
+		jumpID := entry.JumpID
+		j, ok := old[key(entry, i)]
+		if !ok {
+			changes = append(changes, Change{Op: Added, Table: "menu", Index: i, JumpID: &jumpID, New: entry})
+			continue
+		}
+		matched[j] = true
+		for _, field := range changedFields(a[j], entry) {
+			changes = append(changes, Change{Op: Changed, Table: "menu", Index: i, JumpID: &jumpID, Field: field,
+				Old: reflect.ValueOf(a[j]).FieldByName(field).Interface(),
+				New: reflect.ValueOf(entry).FieldByName(field).Interface()})
+		}
+	}
+	for j, entry := range a {
+		if !matched[j] {
+			jumpID := entry.JumpID
+			changes = append(changes, Change{Op: Removed, Table: "menu", Index: j, JumpID: &jumpID, Old: entry})
+		}
+	}
+	return changes
+}
+
+func Areas(a, b []jmp.Area) []Change {
+	changes := []Change{}
+	for i := 0; i < len(a) || i < len(b); i++ {
+		areaIndex := i + 1
+		switch {
+		case i >= len(a):
+			changes = append(changes, Change{Op: Added, Table: "areas", Index: areaIndex, New: b[i]})
+		case i >= len(b):
+			changes = append(changes, Change{Op: Removed, Table: "areas", Index: areaIndex, Old: a[i]})
+		default:
+			changes = append(changes, areaEntries(areaIndex, a[i].Entries, b[i].Entries)...)
+			if !slices.Equal(a[i].StageIDs, b[i].StageIDs) {
+				changes = append(changes, Change{Op: Changed, Table: "areas", Index: areaIndex, Field: "StageIDs",
+					Old: a[i].StageIDs, New: b[i].StageIDs})
+			}
+		}
+	}
+	return changes
+}
+
+// areaEntries compares the entry lists of the area with AreaIndex i. Entries
+// are matched by Index when it is unique in both lists, otherwise by
+// position.
+func areaEntries(i int, a, b []jmp.AreaEntry) []Change {
+	var changes []Change
+	if !uniqueIndexes(a) || !uniqueIndexes(b) {
+		for j := 0; j < len(a) || j < len(b); j++ {
+			field := fmt.Sprintf("Entries[%d]", j)
+			switch {
+			case j >= len(a):
+				changes = append(changes, Change{Op: Added, Table: "areas", Index: i, Field: field, New: b[j]})
+			case j >= len(b):
+				changes = append(changes, Change{Op: Removed, Table: "areas", Index: i, Field: field, Old: a[j]})
+			case a[j] != b[j]:
+				changes = append(changes, Change{Op: Changed, Table: "areas", Index: i, Field: field, Old: a[j], New: b[j]})
+			}
+		}
+		return changes
+	}
+
+	old := make(map[uint16]jmp.AreaEntry, len(a))
+	for _, entry := range a {
+		old[entry.Index] = entry
+	}
+	seen := make(map[uint16]bool)
+	for _, entry := range b {
+		field := fmt.Sprintf("Entries[Index=%d]", entry.Index)
+		prev, ok := old[entry.Index]
+		seen[entry.Index] = true
+		switch {
+		case !ok:
+			changes = append(changes, Change{Op: Added, Table: "areas", Index: i, Field: field, New: entry})
+		case prev.Flags != entry.Flags:
+			changes = append(changes, Change{Op: Changed, Table: "areas", Index: i, Field: field + ".Flags",
+				Old: prev.Flags, New: entry.Flags})
+		}
+	}
+	for _, entry := range a {
+		if !seen[entry.Index] {
+			changes = append(changes, Change{Op: Removed, Table: "areas", Index: i,
+				Field: fmt.Sprintf("Entries[Index=%d]", entry.Index), Old: entry})
+		}
+	}
+	return changes
+}
+
+func uniqueJumpIDs(entries []jmp.MenuEntry) bool {
+	seen := make(map[uint32]bool, len(entries))
+	for _, entry := range entries {
+		if seen[entry.JumpID] {
+			return false
+		}
+		seen[entry.JumpID] = true
+	}
+	return true
+}
+
+func uniqueIndexes(entries []jmp.AreaEntry) bool {
+	seen := make(map[uint16]bool, len(entries))
+	for _, entry := range entries {
+		if seen[entry.Index] {
+			return false
+		}
+		seen[entry.Index] = true
+	}
+	return true
+}
+
+// changedFields returns the names of the fields of two menu entries that
+// differ. Floats are compared by their bits so NaN equals NaN.
+func changedFields(a, b jmp.MenuEntry) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var fields []string
+	for i := 0; i < va.NumField(); i++ {
+		fa, fb := va.Field(i), vb.Field(i)
+		var equal bool
+		switch fa.Kind() {
+		case reflect.Float32:
+			equal = math.Float32bits(float32(fa.Float())) == math.Float32bits(float32(fb.Float()))
+		default:
+			equal = fa.Interface() == fb.Interface()
+		}
+		if !equal {
+			fields = append(fields, va.Type().Field(i).Name)
+		}
+	}
+	return fields
+}
+
+// WriteText prints the changes one per line: "+" for added, "-" for removed
+// and "~" for changed.
+func WriteText(w io.Writer, changes []Change) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No differences")
+		return
+	}
+	for _, c := range changes {
+		fmt.Fprintln(w, c.String())
+	}
+}
+
+// WriteJSON writes r as indented JSON.
+func WriteJSON(w io.Writer, r Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (c Change) String() string {
+	var sb strings.Builder
+	switch c.Op {
+	case Added:
+		sb.WriteString("+ ")
+	case Removed:
+		sb.WriteString("- ")
+	default:
+		sb.WriteString("~ ")
+	}
+	fmt.Fprintf(&sb, "%s[%d]", c.Table, c.Index)
+	if c.JumpID != nil {
+		fmt.Fprintf(&sb, " (JumpID %d)", *c.JumpID)
+	}
+	if c.Field != "" {
+		fmt.Fprintf(&sb, " %s", c.Field)
+	}
+	switch c.Op {
+	case Added:
+		fmt.Fprintf(&sb, ": %s", formatValue(c.New))
+	case Removed:
+		fmt.Fprintf(&sb, ": %s", formatValue(c.Old))
+	default:
+		fmt.Fprintf(&sb, ": %s -> %s", formatValue(c.Old), formatValue(c.New))
+	}
+	return sb.String()
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case jmp.MenuEntry:
+		return fmt.Sprintf("%q / %q, AreaID %d, Pos (%v, %v, %v)", v.Title, v.Description, v.AreaID, v.PosX, v.PosY, v.PosZ)
+	case jmp.AreaEntry:
+		return fmt.Sprintf("[%d,%d]", v.Index, v.Flags)
+	case jmp.Area:
+		return fmt.Sprintf("%d entries, stage IDs %v", len(v.Entries), v.StageIDs)
+	}
+	return fmt.Sprint(v)
+}
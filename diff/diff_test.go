@@ -0,0 +1,181 @@
+package diff
+
+import (
+	"bytes"
+	"mhfjmp-editor/jmp"
+	"reflect"
+	"testing"
+)
+
+func lines(changes []Change) []string {
+	out := []string{}
+	for _, c := range changes {
+		out = append(out, c.String())
+	}
+	return out
+}
+
+func entry(jumpID uint32, title string) jmp.MenuEntry {
+	return jmp.MenuEntry{JumpID: jumpID, Title: title, AreaID: 1}
+}
+
+func TestMenu(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []jmp.MenuEntry
+		want []string
+	}{
+		{"unchanged", []jmp.MenuEntry{entry(1, "A")}, []jmp.MenuEntry{entry(1, "A")}, []string{}},
+		{
+			"reordered by JumpID",
+			[]jmp.MenuEntry{entry(1, "A"), entry(2, "B")},
+			[]jmp.MenuEntry{entry(2, "B"), entry(1, "A2")},
+			[]string{`~ menu[1] (JumpID 1) Title: "A" -> "A2"`},
+		},
+		{
+			"added and removed by JumpID",
+			[]jmp.MenuEntry{entry(1, "A"), entry(2, "B")},
+			[]jmp.MenuEntry{entry(1, "A"), entry(3, "C")},
+			[]string{
+				`+ menu[1] (JumpID 3): "C" / "", AreaID 1, Pos (0, 0, 0)`,
+				`- menu[1] (JumpID 2): "B" / "", AreaID 1, Pos (0, 0, 0)`,
+			},
+		},
+		{
+			"duplicate JumpID matches by position",
+			[]jmp.MenuEntry{entry(1, "A"), entry(1, "B")},
+			[]jmp.MenuEntry{entry(1, "B"), entry(1, "A")},
+			[]string{
+				`~ menu[0] (JumpID 1) Title: "A" -> "B"`,
+				`~ menu[1] (JumpID 1) Title: "B" -> "A"`,
+			},
+		},
+		{
+			"duplicate JumpID in the new file only",
+			[]jmp.MenuEntry{entry(1, "A"), entry(2, "B")},
+			[]jmp.MenuEntry{entry(1, "A"), entry(1, "B"), entry(3, "C")},
+			[]string{
+				`~ menu[1] (JumpID 1) JumpID: 2 -> 1`,
+				`+ menu[2] (JumpID 3): "C" / "", AreaID 1, Pos (0, 0, 0)`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lines(Menu(tt.a, tt.b)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Menu() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAreas(t *testing.T) {
+	area := func(ids []uint16, entries ...jmp.AreaEntry) jmp.Area {
+		return jmp.Area{Entries: entries, StageIDs: ids}
+	}
+	tests := []struct {
+		name string
+		a, b []jmp.Area
+		want []string
+	}{
+		{
+			"entries reordered by Index",
+			[]jmp.Area{area(nil, jmp.AreaEntry{Index: 1, Flags: 2}, jmp.AreaEntry{Index: 3, Flags: 4})},
+			[]jmp.Area{area(nil, jmp.AreaEntry{Index: 3, Flags: 4}, jmp.AreaEntry{Index: 1, Flags: 2})},
+			[]string{},
+		},
+		{
+			"entries changed, added and removed by Index",
+			[]jmp.Area{area(nil, jmp.AreaEntry{Index: 1, Flags: 2}, jmp.AreaEntry{Index: 3, Flags: 4})},
+			[]jmp.Area{area(nil, jmp.AreaEntry{Index: 5, Flags: 6}, jmp.AreaEntry{Index: 1, Flags: 7})},
+			[]string{
+				"+ areas[1] Entries[Index=5]: [5,6]",
+				"~ areas[1] Entries[Index=1].Flags: 2 -> 7",
+				"- areas[1] Entries[Index=3]: [3,4]",
+			},
+		},
+		{
+			"duplicate Index matches by position",
+			[]jmp.Area{area(nil, jmp.AreaEntry{Index: 1, Flags: 2}, jmp.AreaEntry{Index: 1, Flags: 3})},
+			[]jmp.Area{area(nil, jmp.AreaEntry{Index: 1, Flags: 3})},
+			[]string{
+				"~ areas[1] Entries[0]: [1,2] -> [1,3]",
+				"- areas[1] Entries[1]: [1,3]",
+			},
+		},
+		{
+			"stage IDs",
+			[]jmp.Area{area([]uint16{100}), area([]uint16{200})},
+			[]jmp.Area{area([]uint16{100}), area([]uint16{200, 201})},
+			[]string{"~ areas[2] StageIDs: [200] -> [200 201]"},
+		},
+		{
+			"added area",
+			[]jmp.Area{area([]uint16{100})},
+			[]jmp.Area{area([]uint16{100}), area([]uint16{7, 8}, jmp.AreaEntry{Index: 1})},
+			[]string{"+ areas[2]: 1 entries, stage IDs [7 8]"},
+		},
+		{
+			"removed area",
+			[]jmp.Area{area([]uint16{100}), area([]uint16{200})},
+			[]jmp.Area{area([]uint16{100})},
+			[]string{"- areas[2]: 0 entries, stage IDs [200]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lines(Areas(tt.a, tt.b)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Areas() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	var buf bytes.Buffer
+	WriteText(&buf, nil)
+	if got := buf.String(); got != "No differences\n" {
+		t.Errorf("WriteText() of no changes = %q", got)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	a := &jmp.File{Menu: []jmp.MenuEntry{entry(1, "A&B")}, Areas: []jmp.Area{{StageIDs: []uint16{100}}}}
+	b := &jmp.File{Menu: []jmp.MenuEntry{entry(1, "A")}, Areas: []jmp.Area{{StageIDs: []uint16{100, 101}}}}
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, Result{Old: "old.bin", New: "new.bin", Changes: Files(a, b)}); err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "old": "old.bin",
+  "new": "new.bin",
+  "changes": [
+    {
+      "op": "changed",
+      "table": "menu",
+      "index": 0,
+      "jumpId": 1,
+      "field": "Title",
+      "old": "A&B",
+      "new": "A"
+    },
+    {
+      "op": "changed",
+      "table": "areas",
+      "index": 1,
+      "field": "StageIDs",
+      "old": [
+        100
+      ],
+      "new": [
+        100,
+        101
+      ]
+    }
+  ]
+}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteJSON() =\n%s\nwant\n%s", got, want)
+	}
+}
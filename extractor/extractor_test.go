@@ -0,0 +1,192 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"mhfjmp-editor/internal/fixture"
+	"mhfjmp-editor/jmp"
+	"mhfjmp-editor/names"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// writeFixture writes img to a temporary mhfjmp.bin and returns its path.
+func writeFixture(t *testing.T, img fixture.Image) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mhfjmp.bin")
+	if err := os.WriteFile(path, img.Build(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractGolden(t *testing.T) {
+	table, err := names.Load(filepath.Join("testdata", "names.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		opts  Options
+		files []string
+	}{
+		{"csv", Options{}, []string{"menu_entries.csv", "area_entries.csv"}},
+		{"hex", Options{Hex: true}, []string{"menu_entries.csv", "area_entries.csv"}},
+		{"names", Options{Names: table}, []string{"menu_entries.csv", "area_entries.csv"}},
+		{"json", Options{Format: "json"}, []string{"mhfjmp.json"}},
+	}
+	input := writeFixture(t, fixture.Default())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Input = input
+			opts.OutputDir = t.TempDir()
+			if err := Extract(opts); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.files {
+				got, err := os.ReadFile(filepath.Join(opts.OutputDir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				golden := filepath.Join("testdata", tt.name, name)
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(golden), 0777); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(golden, got, 0666); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s differs from %s:\n%s\nwant\n%s", name, golden, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractFormats(t *testing.T) {
+	input := writeFixture(t, fixture.Default())
+	tests := []struct {
+		format  string
+		files   []string
+		wantErr bool
+	}{
+		{"", []string{"menu_entries.csv", "area_entries.csv"}, false},
+		{"json", []string{"mhfjmp.json"}, false},
+		{"csv,json", []string{"menu_entries.csv", "area_entries.csv", "mhfjmp.json"}, false},
+		{"json, csv, json", []string{"menu_entries.csv", "area_entries.csv", "mhfjmp.json"}, false},
+		{"csv,xml", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			dir := t.TempDir()
+			err := Extract(Options{Input: input, OutputDir: dir, Format: tt.format})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			entries, _ := os.ReadDir(dir)
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			want := append([]string(nil), tt.files...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Extract() wrote %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestExtractMissingInput(t *testing.T) {
+	err := Extract(Options{Input: filepath.Join(t.TempDir(), "missing.bin"), OutputDir: t.TempDir()})
+	if err == nil || !errors.Is(err, ErrMissingInput) {
+		t.Errorf("Extract() error = %v, want ErrMissingInput", err)
+	}
+}
+
+func TestMenuEntryData(t *testing.T) {
+	entry := jmp.MenuEntry{JumpID: 500, AreaID: 2, PosX: -0.5, Rotation: 7, Title: "広場", Description: "a, \"quoted\" text"}
+	tests := []struct {
+		name string
+		opts Options
+		want [][]string
+	}{
+		{"decimal", Options{}, [][]string{
+			{"0", "広場", "a, \"quoted\" text", "500", "0", "2", "0", "0", "0", "-0.5", "0", "0", "7", "0", "0", "0", "0"},
+		}},
+		{"hex", Options{Hex: true}, [][]string{
+			{"0", "広場", "a, \"quoted\" text", "0x1F4", "0x0", "0x2", "0x0", "0x0", "0x0", "-0.5", "0", "0", "7", "0", "0", "0", "0"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := csv.NewWriter(&buf)
+			if err := MenuEntryData(w, []jmp.MenuEntry{entry}, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			w.Flush()
+			got, err := csv.NewReader(&buf).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MenuEntryData() wrote\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAreaEntryData(t *testing.T) {
+	areas := []jmp.Area{
+		{Entries: []jmp.AreaEntry{{Index: 1, Flags: 0x10}}, StageIDs: []uint16{100, 301}},
+		{},
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want [][]string
+	}{
+		{"decimal", Options{}, [][]string{{"1", "[1,16] ", "100,301"}, {"2", "", ""}}},
+		{"hex", Options{Hex: true}, [][]string{{"1", "[0x1,0x10] ", "0x64,0x12D"}, {"2", "", ""}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := csv.NewWriter(&buf)
+			if err := AreaEntryData(w, areas, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			w.Flush()
+			got, err := csv.NewReader(&buf).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AreaEntryData() wrote\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
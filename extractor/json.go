@@ -0,0 +1,40 @@
+package extractor
+
+import (
+	"encoding/json"
+	"fmt"
+	"mhfjmp-editor/jmp"
+	"os"
+	"path/filepath"
+)
+
+// processJSON writes the whole parsed file model to mhfjmp.json.
+func processJSON(path string, file *jmp.File) error {
+	// Write empty lists as [] rather than null, without touching the model
+	// the other formats are written from
+	model := *file
+	model.Areas = make([]jmp.Area, len(file.Areas))
+	for i, area := range file.Areas {
+		if area.Entries == nil {
+			area.Entries = []jmp.AreaEntry{}
+		}
+		if area.StageIDs == nil {
+			area.StageIDs = []uint16{}
+		}
+		model.Areas[i] = area
+	}
+
+	out, err := os.Create(filepath.Join(path, "mhfjmp.json"))
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	defer out.Close()
+
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&model); err != nil {
+		return fmt.Errorf("error writing JSON: %w", err)
+	}
+	return out.Close()
+}
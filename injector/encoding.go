@@ -0,0 +1,163 @@
+package injector
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mhfjmp-editor/jmp"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// EncodingPolicy decides what happens to characters Shift-JIS cannot
+// represent in titles and descriptions.
+type EncodingPolicy int
+
+const (
+	// EncodingFail reports every such character and aborts the injection.
+	EncodingFail EncodingPolicy = iota
+	// EncodingSubstitute replaces characters found in the replacement table
+	// and fails on the others.
+	EncodingSubstitute
+	// EncodingStrip drops them.
+	EncodingStrip
+)
+
+func (p EncodingPolicy) String() string {
+	switch p {
+	case EncodingFail:
+		return "fail"
+	case EncodingSubstitute:
+		return "substitute"
+	case EncodingStrip:
+		return "strip"
+	}
+	return fmt.Sprintf("EncodingPolicy(%d)", int(p))
+}
+
+func ParseEncodingPolicy(s string) (EncodingPolicy, error) {
+	switch s {
+	case "fail":
+		return EncodingFail, nil
+	case "substitute":
+		return EncodingSubstitute, nil
+	case "strip":
+		return EncodingStrip, nil
+	}
+	return 0, fmt.Errorf("unknown encoding policy '%s', expected 'fail', 'substitute' or 'strip'", s)
+}
+
+// DefaultReplacements covers the characters spreadsheet applications and
+// keyboards commonly produce that Shift-JIS lacks.
+var DefaultReplacements = map[rune]string{
+	'\u00A0': " ",  // no-break space
+	'¥':      "\\", // yen sign, 0x5C is shown as ¥ in game
+	'©':      "(C)",
+	'–':      "-", // en dash
+	'—':      "―", // em dash
+	'•':      "・", // bullet
+	'™':      "TM",
+	'−':      "-", // minus sign
+	'〜':      "～", // wave dash
+}
+
+// LoadReplacements reads a replacement table from a CSV file with one
+// "character,replacement" pair per line. The character may be written as
+// itself or as U+XXXX. Lines starting with # are ignored.
+func LoadReplacements(path string) (map[rune]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	table := make(map[rune]string)
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		r, err := parseRune(rec[0])
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		table[r] = rec[1]
+	}
+	return table, nil
+}
+
+func parseRune(s string) (rune, error) {
+	if strings.HasPrefix(s, "U+") || strings.HasPrefix(s, "u+") {
+		v, err := strconv.ParseUint(s[2:], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(v)) {
+			return 0, fmt.Errorf("invalid code point '%s'", s)
+		}
+		return rune(v), nil
+	}
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("'%s' is not a single character", s)
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r, nil
+}
+
+// textEncoder applies an EncodingPolicy to CSV text.
+type textEncoder struct {
+	policy       EncodingPolicy
+	replacements map[rune]string
+}
+
+// apply returns s with the policy applied. changed lists what was replaced
+// or removed; the error lists every character that is still not
+// representable.
+func (e textEncoder) apply(s string) (out string, changed []string, err error) {
+	bad := jmp.UnencodableRunes(s)
+	if len(bad) == 0 {
+		return s, nil, nil
+	}
+
+	var errs []error
+	if e.policy == EncodingFail {
+		for _, b := range bad {
+			errs = append(errs, b)
+		}
+		return s, nil, errors.Join(errs...)
+	}
+
+	var sb strings.Builder
+	pos := 0
+	for _, r := range s {
+		pos++
+		if jmp.IsShiftJIS(r) {
+			sb.WriteRune(r)
+			continue
+		}
+		if e.policy == EncodingStrip {
+			changed = append(changed, fmt.Sprintf("removed %U '%c' at character %d", r, r, pos))
+			continue
+		}
+		repl, ok := e.replacements[r]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w and has no replacement", &jmp.EncodeError{Rune: r, Pos: pos}))
+			continue
+		}
+		if len(jmp.UnencodableRunes(repl)) > 0 {
+			errs = append(errs, fmt.Errorf("%w and its replacement '%s' is not representable either",
+				&jmp.EncodeError{Rune: r, Pos: pos}, repl))
+			continue
+		}
+		changed = append(changed, fmt.Sprintf("replaced %U '%c' at character %d with '%s'", r, r, pos, repl))
+		sb.WriteString(repl)
+	}
+	return sb.String(), changed, errors.Join(errs...)
+}
@@ -0,0 +1,183 @@
+package injector
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"mhfjmp-editor/internal/fixture"
+	"mhfjmp-editor/jmp"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTextEncoder(t *testing.T) {
+	table := map[rune]string{'™': "TM", '🏠': "家", '🎵': "🎶"}
+	tests := []struct {
+		name    string
+		policy  EncodingPolicy
+		in      string
+		out     string
+		changed []string
+		bad     []jmp.EncodeError // wrapped in the error, in order
+	}{
+		{"encodable", EncodingFail, "マイハウス ABC", "マイハウス ABC", nil, nil},
+		{"fail", EncodingFail, "A™B🏠", "A™B🏠", nil, []jmp.EncodeError{{Rune: '™', Pos: 2}, {Rune: '🏠', Pos: 4}}},
+		{"substitute", EncodingSubstitute, "A™Bマイ🏠", "ATMBマイ家", []string{
+			"replaced U+2122 '™' at character 2 with 'TM'",
+			"replaced U+1F3E0 '🏠' at character 6 with '家'",
+		}, nil},
+		{"substitute without replacement", EncodingSubstitute, "A™B🐟", "ATMB", []string{
+			"replaced U+2122 '™' at character 2 with 'TM'",
+		}, []jmp.EncodeError{{Rune: '🐟', Pos: 4}}},
+		{"unencodable replacement", EncodingSubstitute, "🎵1", "1", nil, []jmp.EncodeError{{Rune: '🎵', Pos: 1}}},
+		{"strip", EncodingStrip, "A™Bマイ🏠", "ABマイ", []string{
+			"removed U+2122 '™' at character 2",
+			"removed U+1F3E0 '🏠' at character 6",
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, changed, err := textEncoder{policy: tt.policy, replacements: table}.apply(tt.in)
+			if out != tt.out {
+				t.Errorf("apply(%q) = %q, want %q", tt.in, out, tt.out)
+			}
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("changed = %q, want %q", changed, tt.changed)
+			}
+			if len(tt.bad) == 0 {
+				if err != nil {
+					t.Errorf("error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, jmp.ErrUnencodable) {
+				t.Fatalf("error = %v, want ErrUnencodable", err)
+			}
+			errs := err.(interface{ Unwrap() []error }).Unwrap()
+			if len(errs) != len(tt.bad) {
+				t.Fatalf("error = %v, want %d problem(s)", err, len(tt.bad))
+			}
+			for i, e := range errs {
+				var ee *jmp.EncodeError
+				if !errors.As(e, &ee) || *ee != tt.bad[i] {
+					t.Errorf("problem %d = %v, want %U at character %d", i, e, tt.bad[i].Rune, tt.bad[i].Pos)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadReplacements(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[rune]string
+		err  string
+	}{
+		{"table", "# character,replacement\n™,TM\nU+1F3E0,家\nu+00e9,e\n\" \",\" \"\n", map[rune]string{
+			'™': "TM", '🏠': "家", 'é': "e", ' ': " ",
+		}, ""},
+		{"several characters", "™,TM\nab,x\n", nil, ":2: 'ab' is not a single character"},
+		{"empty character", ",x\n", nil, ":1: '' is not a single character"},
+		{"bad code point", "U+D800,x\n", nil, ":1: invalid code point 'U+D800'"},
+		{"not hexadecimal", "U+12G,x\n", nil, ":1: invalid code point 'U+12G'"},
+		{"missing replacement", "™,TM\n©\n", nil, "wrong number of fields"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "map.csv")
+			if err := os.WriteFile(path, []byte(tt.data), 0666); err != nil {
+				t.Fatal(err)
+			}
+			table, err := LoadReplacements(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("LoadReplacements() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(table, tt.want) {
+				t.Errorf("LoadReplacements() = %q, want %q", table, tt.want)
+			}
+		})
+	}
+}
+
+// The log names the file, line and column of every character replaced or
+// removed, the error those of every one left.
+func TestInjectEncoding(t *testing.T) {
+	tests := []struct {
+		policy EncodingPolicy
+		title  string
+		logs   []string
+	}{
+		{EncodingSubstitute, "ギルド酒場 - TM", []string{
+			"menu_entries.csv line 3: Title: replaced U+2013 '–' at character 7 with '-'",
+			"menu_entries.csv line 3: Title: replaced U+2122 '™' at character 9 with 'TM'",
+		}},
+		{EncodingStrip, "ギルド酒場  ", []string{
+			"menu_entries.csv line 3: Title: removed U+2013 '–' at character 7",
+			"menu_entries.csv line 3: Title: removed U+2122 '™' at character 9",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			input, dir := extract(t, fixture.Default(), "csv")
+			editFile(t, filepath.Join(dir, "menu_entries.csv"), "ギルド酒場,", "ギルド酒場 – ™,")
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(io.Discard)
+
+			opts := Options{Input: input, Dir: dir, Output: filepath.Join(dir, "out.bin"), Encoding: tt.policy}
+			if _, err := Inject(opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := parseFile(t, opts.Output).Menu[1].Title; got != tt.title {
+				t.Errorf("Title = %q, want %q", got, tt.title)
+			}
+			for _, want := range tt.logs {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("log does not contain %q", want)
+				}
+			}
+		})
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		input, dir := extract(t, fixture.Default(), "csv")
+		editFile(t, filepath.Join(dir, "menu_entries.csv"), "説明 マイハウス,", "説明 マイハウス🏠,")
+		editFile(t, filepath.Join(dir, "menu_entries.csv"), "Rasta Bar,", "Rasta Bar™,")
+		opts := Options{Input: input, Dir: dir, Output: filepath.Join(dir, "out.bin"), Encoding: EncodingSubstitute,
+			Replacements: map[rune]string{}}
+		_, err := Inject(opts)
+		var pe ParseErrors
+		if !errors.Is(err, ErrEncoding) || !errors.As(err, &pe) {
+			t.Fatalf("Inject() error = %v, want ErrEncoding in a ParseErrors", err)
+		}
+		want := []struct {
+			line   int
+			column string
+			ee     jmp.EncodeError
+		}{
+			{4, "Description", jmp.EncodeError{Rune: '🏠', Pos: 9}},
+			{5, "Title", jmp.EncodeError{Rune: '™', Pos: 10}},
+		}
+		if len(pe) != len(want) {
+			t.Fatalf("Inject() error = %v, want %d problems", err, len(want))
+		}
+		for i, fe := range pe {
+			var ee *jmp.EncodeError
+			if fe.Line != want[i].line || fe.Column != want[i].column || !errors.As(fe, &ee) || *ee != want[i].ee {
+				t.Errorf("problem %d = %v, want line %d, %s, %U at character %d",
+					i, fe, want[i].line, want[i].column, want[i].ee.Rune, want[i].ee.Pos)
+			}
+		}
+	})
+}
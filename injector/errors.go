@@ -0,0 +1,48 @@
+package injector
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mhfjmp-editor/jmp"
+)
+
+// Errors returned by Inject can be tested with errors.Is against these.
+var (
+	// ErrMissingInput is a binary, CSV or JSON file that does not exist.
+	// It is jmp.ErrMissingInput, which Extract returns too.
+	ErrMissingInput = jmp.ErrMissingInput
+	// ErrMalformedInput is a CSV or JSON file with an unreadable structure
+	// or value. The error is a ParseErrors listing every problem.
+	ErrMalformedInput = errors.New("malformed input")
+	// ErrOverflow is a text pool larger than MarshalOptions.StringLimit.
+	ErrOverflow = jmp.ErrStringPoolOverflow
+	// ErrEncoding is text Shift-JIS cannot represent. When it comes from the
+	// CSV or JSON input the error is also a ParseErrors.
+	ErrEncoding = jmp.ErrUnencodable
+)
+
+var errZeroStageID = fmt.Errorf("%w, the IDs after it would be lost", jmp.ErrZeroStageID)
+
+// Is reports a FieldError as ErrMalformedInput unless it is an encoding
+// problem, which matches ErrEncoding through Unwrap instead.
+func (e *FieldError) Is(target error) bool {
+	return target == ErrMalformedInput && !errors.Is(e.Err, ErrEncoding)
+}
+
+func (e ParseErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, fe := range e {
+		errs[i] = fe
+	}
+	return errs
+}
+
+// missingInput marks an error for a file that does not exist with
+// ErrMissingInput.
+func missingInput(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrMissingInput, err)
+	}
+	return err
+}
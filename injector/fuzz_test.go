@@ -0,0 +1,115 @@
+package injector
+
+import (
+	"fmt"
+	"mhfjmp-editor/names"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func FuzzParseAreaEntries(f *testing.F) {
+	for _, s := range []string{"", "[1,2] [3,4] ", "[0x10,0xFFFF]", "[1,2][3,4]", "[1,2,3]", "[,]", "[65536,0]", " [ 1 , 2 ] "} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		entries, err := parseAreaEntries(s)
+		if err != nil {
+			return
+		}
+		var sb strings.Builder
+		for _, e := range entries {
+			fmt.Fprintf(&sb, "[%d,%d] ", e.Index, e.Flags)
+		}
+		again, err := parseAreaEntries(sb.String())
+		if err != nil || !reflect.DeepEqual(again, entries) {
+			t.Fatalf("%q parsed to %v, written back as %q parses to %v, %v", s, entries, sb.String(), again, err)
+		}
+	})
+}
+
+func FuzzParseStageIds(f *testing.F) {
+	for _, s := range []string{"", "100,101", "100 101", "0x64, 0x65", "1,0,2", "Town Gate,5", "town gate", "65536", ",,"} {
+		f.Add(s)
+	}
+	table := testNames(f).Stages
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, tab := range []*names.Table{nil, table} {
+			ids, err := parseStageIds(s, tab)
+			for _, id := range ids {
+				if id == 0 {
+					t.Fatalf("parseStageIds(%q) = %v, holds a 0", s, ids)
+				}
+			}
+			if err != nil {
+				continue
+			}
+			list := make([]string, len(ids))
+			for i, id := range ids {
+				list[i] = fmt.Sprint(id)
+			}
+			again, err := parseStageIds(strings.Join(list, ","), tab)
+			if err != nil || !reflect.DeepEqual(again, ids) {
+				t.Fatalf("%q parsed to %v, written back parses to %v, %v", s, ids, again, err)
+			}
+		}
+	})
+}
+
+// FuzzReadCSV feeds the same bytes to the menu and area CSV readers. Whatever
+// strict mode accepts, lenient mode must read the same way.
+func FuzzReadCSV(f *testing.F) {
+	f.Add("ID,Title,Description,JumpID,Unk0C,AreaID,AreaID2,AreaID3,Unk18,PosX,PosY,PosZ,Rotation,PosX1,PosY1,PosZ1,Rotation1\n" +
+		"0,広場,説明,1000,0,1,0,0,0,1.5,2,-3.25,0,0,0,0,0\n")
+	f.Add("AreaIndex,AreaEntries,StageIds\n1,\"[1,2] [3,4] \",\"100,101\"\n2,,\n")
+	f.Add("AreaIndex,lenEntryData,AreaEntries,StageIds\n1,1,[1,2],Town Gate\n")
+	f.Add("AreaIndex,AreaEntries,StageIds\n1,\"[1,2\n")
+	f.Add("")
+	table := testNames(f)
+	dir := f.TempDir()
+	f.Fuzz(func(t *testing.T, data string) {
+		path := filepath.Join(dir, "input.csv")
+		if err := os.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		for _, tab := range []*names.Mapping{nil, table} {
+			strict := Options{Names: tab}
+			lenient := Options{Names: tab, Lenient: true}
+
+			menu, err := readMenuRows(path, strict)
+			if err == nil {
+				again, err := readMenuRows(path, lenient)
+				if err != nil || !reflect.DeepEqual(again, menu) {
+					t.Fatalf("lenient menu read differs: %v", err)
+				}
+			} else {
+				readMenuRows(path, lenient)
+			}
+
+			areas, err := readAreaRows(path, strict)
+			if err == nil {
+				again, err := readAreaRows(path, lenient)
+				if err != nil || !reflect.DeepEqual(again, areas) {
+					t.Fatalf("lenient area read differs: %v", err)
+				}
+			} else {
+				readAreaRows(path, lenient)
+			}
+		}
+	})
+}
+
+func testNames(tb testing.TB) *names.Mapping {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "names.csv")
+	if err := os.WriteFile(path, []byte("Kind,ID,Name\nstage,100,Town Gate\narea,2,Guild Hall\n"), 0666); err != nil {
+		tb.Fatal(err)
+	}
+	table, err := names.Load(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return table
+}
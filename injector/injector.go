@@ -1,423 +1,413 @@
 package injector
 
 import (
-	"encoding/binary"
-	"encoding/csv"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"log"
-	"math"
+	"mhfjmp-editor/container"
+	"mhfjmp-editor/jmp"
+	"mhfjmp-editor/names"
+	"mhfjmp-editor/patch"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
+)
 
-	"golang.org/x/text/encoding/japanese"
-	"golang.org/x/text/transform"
+var (
+	menuColumns = []string{"Title", "Description", "JumpID", "Unk0C", "AreaID", "AreaID2", "AreaID3", "Unk18",
+		"PosX", "PosY", "PosZ", "Rotation", "PosX1", "PosY1", "PosZ1", "Rotation1"}
+	// lenEntryData is no longer required, it is derived from AreaEntries
+	areaColumns = []string{"AreaIndex", "AreaEntries", "StageIds"}
 )
 
-type AreaEntry struct {
-	Index uint16
-	Flags uint16
+// menuRow is a menu entry and the line of menu_entries.csv it was read from.
+type menuRow struct {
+	line  int
+	entry jmp.MenuEntry
 }
 
-type Area struct {
-	pEntryData   uint32
-	lenEntryData uint32
-	pStageIds    uint32
-	entry        []AreaEntry
-	stageIds     []uint16
+// areaRow is an area and the line of area_entries.csv it was read from,
+// with the AreaIndex written on that line.
+type areaRow struct {
+	line  int
+	index uint32
+	area  jmp.Area
 }
 
-type MenuEntry struct {
-	JumpID      uint32
-	Unk0C       uint32
-	AreaID      uint16
-	AreaID2     uint16
-	AreaID3     uint16
-	Unk18       uint16
-	PosX        float32
-	PosY        float32
-	PosZ        float32
-	Unk28       uint32
-	PosX1       float32
-	PosY1       float32
-	PosZ1       float32
-	Unk38       uint32
-	Title       string
-	Description string
+func loadMenuEntriesFromCSV(path string, opts Options) ([]jmp.MenuEntry, error) {
+	rows, err := readMenuRows(path, opts)
+	var entries []jmp.MenuEntry
+	for _, row := range rows {
+		entry := row.entry
+		log.Printf("Entry %d loaded: JumpID=%d, AreaID=%d, Pos=(%.2f,%.2f,%.2f)",
+			len(entries), entry.JumpID, entry.AreaID, entry.PosX, entry.PosY, entry.PosZ)
+		entries = append(entries, entry)
+	}
+	return entries, err
+}
+
+func loadAreaEntriesFromCSV(path string, opts Options) ([]jmp.Area, uint32, error) {
+	rows, err := readAreaRows(path, opts)
+	var areas []jmp.Area
+	var numAreas uint32 = 0
+	for _, row := range rows {
+		// Update numAreas with the last AreaIndex found
+		numAreas = row.index
+		log.Printf("Area %d loaded: Entries=%d, StageIds=%d", numAreas, len(row.area.Entries), len(row.area.StageIDs))
+		areas = append(areas, row.area)
+	}
+
+	log.Printf("Total areas loaded: %d (using last AreaIndex: %d)", len(areas), numAreas)
+	return areas, numAreas, err
 }
 
-func loadMenuEntriesFromCSV(path string) ([]MenuEntry, error) {
+// readMenuRows reads menu_entries.csv. Along with the error it returns every
+// row it could read, bad values set to 0.
+func readMenuRows(path string, opts Options) ([]menuRow, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, missingInput(err)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	p := newCSVParser(path, opts)
+	table, err := readCSVTable(file, p, menuColumns)
 	if err != nil {
 		return nil, err
 	}
 
-	var entries []MenuEntry
-	for i, rec := range records {
-		if i == 0 {
-			continue // Skip header
+	var rows []menuRow
+	for {
+		rec, err := table.next(p)
+		if err != nil {
+			return nil, err
 		}
-		if len(rec) < 17 {
-			log.Printf("Warning: Line %d does not have enough columns (%d/17)", i, len(rec))
-			continue
+		if rec == nil {
+			break
 		}
 
-		entry := MenuEntry{
-			Title:       rec[1],
-			Description: rec[2],
-			JumpID:      parseUint32(rec[3]),
-			Unk0C:       parseUint32(rec[4]),
-			AreaID:      parseUint16(rec[5]),
-			AreaID2:     parseUint16(rec[6]),
-			AreaID3:     parseUint16(rec[7]),
-			Unk18:       parseUint16(rec[8]),
-			PosX:        parseFloat32(rec[9]),
-			PosY:        parseFloat32(rec[10]),
-			PosZ:        parseFloat32(rec[11]),
-			Unk28:       parseUint32(rec[12]),
-			PosX1:       parseFloat32(rec[13]),
-			PosY1:       parseFloat32(rec[14]),
-			PosZ1:       parseFloat32(rec[15]),
-			Unk38:       parseUint32(rec[16]),
+		entry := jmp.MenuEntry{
+			Title:       p.text("Title", table.get(rec, "Title")),
+			Description: p.text("Description", table.get(rec, "Description")),
+			JumpID:      p.uint32("JumpID", table.get(rec, "JumpID")),
+			Unk0C:       p.uint32("Unk0C", table.get(rec, "Unk0C")),
+			AreaID:      p.areaID("AreaID", table.get(rec, "AreaID")),
+			AreaID2:     p.areaID("AreaID2", table.get(rec, "AreaID2")),
+			AreaID3:     p.areaID("AreaID3", table.get(rec, "AreaID3")),
+			Unk18:       p.uint16("Unk18", table.get(rec, "Unk18")),
+			PosX:        p.float32("PosX", table.get(rec, "PosX")),
+			PosY:        p.float32("PosY", table.get(rec, "PosY")),
+			PosZ:        p.float32("PosZ", table.get(rec, "PosZ")),
+			Rotation:    p.uint32("Rotation", table.get(rec, "Rotation")),
+			PosX1:       p.float32("PosX1", table.get(rec, "PosX1")),
+			PosY1:       p.float32("PosY1", table.get(rec, "PosY1")),
+			PosZ1:       p.float32("PosZ1", table.get(rec, "PosZ1")),
+			Rotation1:   p.uint32("Rotation1", table.get(rec, "Rotation1")),
 		}
-
-		log.Printf("Entry %d loaded: JumpID=%d, AreaID=%d, Pos=(%.2f,%.2f,%.2f)",
-			i, entry.JumpID, entry.AreaID, entry.PosX, entry.PosY, entry.PosZ)
-		entries = append(entries, entry)
+		rows = append(rows, menuRow{line: p.line, entry: entry})
 	}
-	return entries, nil
+	return rows, p.Err()
 }
 
-func loadAreaEntriesFromCSV(path string) ([]Area, uint32, error) {
+// readAreaRows reads area_entries.csv. Along with the error it returns every
+// row it could read; malformed pairs and stage IDs are left out.
+func readAreaRows(path string, opts Options) ([]areaRow, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, 0, err
+		return nil, missingInput(err)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	p := newCSVParser(path, opts)
+	table, err := readCSVTable(file, p, areaColumns)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	var areas []Area
-	var numAreas uint32 = 0
-
-	for i, rec := range records {
-		if i == 0 {
-			continue // Skip header
+	var rows []areaRow
+	for {
+		rec, err := table.next(p)
+		if err != nil {
+			return nil, err
 		}
-		if len(rec) < 4 {
-			log.Printf("Warning: Line %d does not have enough columns (%d/4)", i, len(rec))
-			continue
+		if rec == nil {
+			break
 		}
 
-		// Read AreaIndex from first column
-		areaIndex := parseUint32(rec[0])
-		// Update numAreas with the last AreaIndex found
-		numAreas = areaIndex
-		log.Printf("Found AreaIndex: %d", areaIndex)
-
-		area := Area{
-			lenEntryData: parseUint32(rec[1]),
-			entry:        parseAreaEntries(rec[2]),
-			stageIds:     parseStageIds(rec[3]),
+		row := areaRow{
+			line:  p.line,
+			index: p.uint32("AreaIndex", table.get(rec, "AreaIndex")),
 		}
-
-		log.Printf("Area %d loaded: Entries=%d, StageIds=%d", i, len(area.entry), len(area.stageIds))
-		areas = append(areas, area)
+		row.area.Entries, err = parseAreaEntries(table.get(rec, "AreaEntries"))
+		if err != nil {
+			p.fail("AreaEntries", table.get(rec, "AreaEntries"), err)
+		} else if table.has("lenEntryData") {
+			// The file stores the number of pairs; a column from an older
+			// extract must agree with them
+			v := table.get(rec, "lenEntryData")
+			if n, err := parseUint32(v); err != nil {
+				p.fail("lenEntryData", v, err)
+			} else if int(n) != len(row.area.Entries) {
+				p.fail("lenEntryData", v, fmt.Errorf("AreaEntries has %d [Index,Flags] pairs; remove the column "+
+					"or run 'migrate', the count is derived from AreaEntries", len(row.area.Entries)))
+			}
+		}
+		row.area.StageIDs, err = parseStageIds(table.get(rec, "StageIds"), opts.Names.Table(names.Stage))
+		if err != nil {
+			p.fail("StageIds", table.get(rec, "StageIds"), err)
+		}
+		rows = append(rows, row)
 	}
-
-	log.Printf("Total areas loaded: %d (using last AreaIndex: %d)", len(areas), numAreas)
-	return areas, numAreas, nil
+	return rows, p.Err()
 }
 
-func parseAreaEntries(s string) []AreaEntry {
-	var entries []AreaEntry
+// parseAreaEntries parses "[idx,flags] [idx,flags] ...". Malformed pairs are
+// left out of the result and reported in the error.
+func parseAreaEntries(s string) ([]jmp.AreaEntry, error) {
+	var entries []jmp.AreaEntry
+	var errs []error
 	// Split by spaces only, keeping the [%s,%s] pairs intact
 	parts := strings.Fields(s)
 	for _, part := range parts {
-		var idx, flags uint16
 		// Remove brackets and split by comma
-		part = strings.Trim(part, "[]")
-		values := strings.Split(part, ",")
-		if len(values) == 2 {
-			idx = parseUint16(values[0])
-			flags = parseUint16(values[1])
-			entries = append(entries, AreaEntry{Index: idx, Flags: flags})
-		} else {
-			log.Printf("Warning: Invalid entry format '%s', expected [idx,flags]", part)
+		values := strings.Split(strings.Trim(part, "[]"), ",")
+		if len(values) != 2 {
+			errs = append(errs, fmt.Errorf("invalid entry format '%s', expected [idx,flags]", part))
+			continue
+		}
+		idx, err := parseUint16(values[0])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("entry '%s': index: %w", part, err))
+			continue
+		}
+		flags, err := parseUint16(values[1])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("entry '%s': flags: %w", part, err))
+			continue
 		}
+		entries = append(entries, jmp.AreaEntry{Index: idx, Flags: flags})
 	}
-	return entries
+	return entries, errors.Join(errs...)
 }
 
-func parseStageIds(s string) []uint16 {
+// parseStageIds parses a list of stage IDs separated by spaces and/or
+// commas. With a name mapping an ID may also be given by name; names can
+// contain spaces, so a list holding a comma is then only split on commas.
+// Invalid IDs and 0, which would end the list in the binary, are left out of
+// the result and reported in the error.
+func parseStageIds(s string, table *names.Table) ([]uint16, error) {
 	var ids []uint16
-	// Split by both spaces and commas
-	parts := strings.FieldsFunc(s, func(r rune) bool {
-		return r == ' ' || r == ','
-	})
+	var errs []error
+	var parts []string
+	switch _, isName := table.ID(s); {
+	case table == nil:
+		// Split by both spaces and commas
+		parts = strings.FieldsFunc(s, func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+	case strings.Contains(s, ","):
+		for _, part := range strings.Split(s, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	case isName:
+		parts = []string{strings.TrimSpace(s)}
+	default:
+		parts = strings.Fields(s)
+	}
 	for _, part := range parts {
-		id, err := strconv.ParseUint(part, 10, 16)
+		id, err := parseID(part, table)
+		if err == nil && id == 0 {
+			err = errZeroStageID
+		}
 		if err != nil {
-			log.Printf("Warning: Error parsing stage ID '%s': %v", part, err)
+			errs = append(errs, fmt.Errorf("stage ID '%s': %w", part, err))
 			continue
 		}
-		ids = append(ids, uint16(id))
+		ids = append(ids, id)
 	}
-	return ids
+	return ids, errors.Join(errs...)
 }
 
-func InjectData() {
-	entries, err := loadMenuEntriesFromCSV("output/menu_entries.csv")
-	if err != nil {
-		log.Fatalf("Error loading CSV: %v", err)
-	}
-	log.Printf("Number of entries loaded from CSV: %d", len(entries))
-
-	areas, numAreas, err := loadAreaEntriesFromCSV("output/area_entries.csv")
-	if err != nil {
-		log.Fatalf("Error loading area entries: %v", err)
-	}
-	log.Printf("Number of areas loaded from CSV: %d", len(areas))
-
-	data, err := os.ReadFile("input/mhfjmp.bin")
-	if err != nil {
-		log.Fatalf("Error reading mhfjmp.bin: %v", err)
-	}
-	log.Printf("Size of mhfjmp.bin file: %d bytes", len(data))
-
-	// Calculate menu entry section offset
-	soMenuEntry := len(data) + 17 // Original file size + header size
-	const menuEntrySize = 56
-
-	existingEntries := 0 // We're not using existing entries anymore
-	log.Printf("Number of existing entries: %d", existingEntries)
-
-	totalEntriesSize := len(entries) * menuEntrySize
-	log.Printf("Total size needed for entries: %d bytes", totalEntriesSize)
-
-	// Calculate text section offset after menu entries
-	textSectionOffset := soMenuEntry + totalEntriesSize
-	log.Printf("Text section offset: %d", textSectionOffset)
-
-	// Calculate total size needed for areas
-	var totalAreaSize uint32
-	for _, area := range areas {
-		areaSize := 12 + uint32(len(area.entry))*4 + uint32(len(area.stageIds))*2 + 2
-		totalAreaSize += areaSize
-	}
-
-	// Calculate area section offset after text section
-	areaSectionOffset := textSectionOffset + 3072 + 6 // Reserve 3KB for text section + 6 bytes padding
-	log.Printf("Area section offset: %d", areaSectionOffset)
-
-	// Calculate total size needed for the entire file
-	totalSize := areaSectionOffset + int(totalAreaSize)
-	log.Printf("Total size needed: %d bytes (Text section: %d, Area section: %d)",
-		totalSize, 3072, totalAreaSize)
-
-	output := make([]byte, totalSize)
-	copy(output, data)
-	log.Printf("Output buffer size: %d", len(output))
-
-	// Calculate dynamic pointer to menu entries
-	menuEntryPointer := uint32(soMenuEntry)
-	log.Printf("Menu entry pointer: 0x%X", menuEntryPointer)
-
-	// Write dynamic pointer at offset 0x00
-	binary.LittleEndian.PutUint16(output[0x00:], uint16(menuEntryPointer))
-	binary.LittleEndian.PutUint16(output[0x02:], uint16(menuEntryPointer>>16))
-
-	// Write number of area entries at offset 0x08
-	log.Printf("Number of area entries: %d", numAreas)
-	binary.LittleEndian.PutUint32(output[0x08:], numAreas)
-
-	for i := 0; i < 16; i++ {
-		output[len(data)+i] = 0x00
-	}
-	output[len(data)+16] = 0xFF
-
-	// Write menu entries
-	var stringSection []byte
-	var textOffsets []uint32
+var (
+	DefaultInput  = filepath.Join("input", "mhfjmp.bin")
+	DefaultDir    = "output"
+	DefaultOutput = filepath.Join("output", "mhfjmp_patched.bin")
+)
 
-	// First, collect all text offsets
-	for _, entry := range entries {
-		titleOffset := uint32(textSectionOffset + len(stringSection))
-		stringSection = append(stringSection, encodeShiftJIS(entry.Title)...)
-		stringSection = append(stringSection, 0x00)
+// Options controls what Inject reads and how it writes the patched file.
+type Options struct {
+	// Input is the original mhfjmp.bin. Empty means DefaultInput.
+	Input string
+	// Dir holds the CSV or JSON files. Empty means DefaultDir.
+	Dir string
+	// Output is the patched file. Empty means DefaultOutput.
+	Output string
+
+	// MarshalOptions lays out Output. The zero Mode is jmp.ModeRebuild,
+	// whereas the inject command defaults to -mode append; set Mode to
+	// jmp.ModeAppend for the command's behaviour.
+	jmp.MarshalOptions
+	// Format is "csv" (menu_entries.csv and area_entries.csv) or "json"
+	// (mhfjmp.json). Empty means "csv".
+	Format string
+	// Lenient restores the old CSV behaviour: bad numbers become 0 and short
+	// rows are skipped, with a warning in the log. By default any problem
+	// aborts the injection.
+	Lenient bool
+	// Encoding decides what happens to characters Shift-JIS cannot
+	// represent. Replacements is the table used by EncodingSubstitute; nil
+	// means DefaultReplacements.
+	Encoding     EncodingPolicy
+	Replacements map[rune]string
+	// Names lets CSV AreaIDs and stage IDs be written as names, each looked
+	// up in its own table.
+	Names *names.Mapping
+
+	// IPS and BPS, if set, are where to write a patch turning Input into
+	// Output.
+	IPS string
+	BPS string
+}
 
-		descriptionOffset := uint32(textSectionOffset + len(stringSection))
-		stringSection = append(stringSection, encodeShiftJIS(entry.Description)...)
-		stringSection = append(stringSection, 0x00)
+// Report summarizes a successful Inject. If only writing a patch failed,
+// Output has been written and the Report describes it.
+type Report struct {
+	Input  string
+	Output string
+	// Entries and Areas are the number of menu entries and areas written.
+	Entries int
+	Areas   int
+	// SourceSize is the size of Input, Layout describes Output.
+	SourceSize int
+	Layout     jmp.Layout
+	// SourceCRC is the CRC32 of Input, which IPS patches must be checked
+	// against since they do not store it.
+	SourceCRC uint32
+	Patches   []string
+	// Layers are the ECD and JKR layers of Input, which Output was wrapped
+	// in again.
+	Layers []container.Layer
+}
 
-		textOffsets = append(textOffsets, titleOffset, descriptionOffset)
+// Inject builds a patched mhfjmp.bin from the CSV or JSON files in opts.Dir.
+// Nothing is written unless the whole input is valid. If opts.Input is ECD
+// encrypted or JKR compressed the output is encrypted and compressed the
+// same way, and patches are made between the wrapped files.
+func Inject(opts Options) (Report, error) {
+	if opts.Input == "" {
+		opts.Input = DefaultInput
 	}
-
-	// Then write menu entries
-	for i, entry := range entries {
-		base := soMenuEntry + (i * menuEntrySize)
-		log.Printf("Writing menu entry %d at offset %d", i, base)
-
-		if base+menuEntrySize > len(output) {
-			log.Fatalf("Buffer too small for menu entry %d (offset %d + %d > %d)",
-				i, base, menuEntrySize, len(output))
-		}
-
-		binary.LittleEndian.PutUint32(output[base:], entry.JumpID)
-		binary.LittleEndian.PutUint32(output[base+4:], entry.Unk0C)
-		binary.LittleEndian.PutUint16(output[base+8:], entry.AreaID)
-		binary.LittleEndian.PutUint16(output[base+10:], entry.AreaID2)
-		binary.LittleEndian.PutUint16(output[base+12:], entry.AreaID3)
-		binary.LittleEndian.PutUint16(output[base+14:], entry.Unk18)
-		writeFloat32(output[base+16:], entry.PosX)
-		writeFloat32(output[base+20:], entry.PosY)
-		writeFloat32(output[base+24:], entry.PosZ)
-		binary.LittleEndian.PutUint32(output[base+28:], entry.Unk28)
-		writeFloat32(output[base+32:], entry.PosX1)
-		writeFloat32(output[base+36:], entry.PosY1)
-		writeFloat32(output[base+40:], entry.PosZ1)
-		binary.LittleEndian.PutUint32(output[base+44:], entry.Unk38)
-		binary.LittleEndian.PutUint32(output[base+48:], textOffsets[i*2])
-		binary.LittleEndian.PutUint32(output[base+52:], textOffsets[i*2+1])
-
-		log.Printf("Menu entry %d written: JumpID=%d, AreaID=%d, Pos=(%.2f,%.2f,%.2f)",
-			i, entry.JumpID, entry.AreaID, entry.PosX, entry.PosY, entry.PosZ)
+	if opts.Dir == "" {
+		opts.Dir = DefaultDir
 	}
-
-	// Write text section
-	copy(output[textSectionOffset:], stringSection)
-	log.Printf("Text section written at offset %d (size: %d)", textSectionOffset, len(stringSection))
-
-	// Write areas
-	headersOffset := areaSectionOffset
-	dataOffset := headersOffset + len(areas)*12 // 12 bytes per header
-
-	// First pass: write all headers
-	var cumulativeOffset uint32 = uint32(dataOffset)
-	for i, area := range areas {
-		headerOffset := headersOffset + i*12
-		// Calculate data offset for this area
-		dataOffsetForArea := cumulativeOffset
-		stageIdsOffset := dataOffsetForArea + uint32(len(area.entry)*4)
-
-		log.Printf("Writing header for area %d at offset %d (data at %d)", i, headerOffset, dataOffsetForArea)
-		binary.LittleEndian.PutUint32(output[headerOffset:], dataOffsetForArea)
-		binary.LittleEndian.PutUint32(output[headerOffset+4:], uint32(len(area.entry)))
-		binary.LittleEndian.PutUint32(output[headerOffset+8:], stageIdsOffset)
-
-		// Calculate next area's offset
-		areaSize := uint32(len(area.entry)*4 + len(area.stageIds)*2 + 2) // +2 for terminator
-		cumulativeOffset += areaSize
+	if opts.Output == "" {
+		opts.Output = DefaultOutput
 	}
-
-	// Second pass: write all data
-	currentDataOffset := dataOffset
-	for i, area := range areas {
-		log.Printf("Writing data for area %d at offset %d", i, currentDataOffset)
-
-		// Write entries
-		for j, entry := range area.entry {
-			entryOffset := currentDataOffset + j*4
-			binary.LittleEndian.PutUint16(output[entryOffset:], entry.Index)
-			binary.LittleEndian.PutUint16(output[entryOffset+2:], entry.Flags)
+	report := Report{Input: opts.Input, Output: opts.Output}
+
+	var entries []jmp.MenuEntry
+	var areas []jmp.Area
+	switch opts.Format {
+	case "", "csv":
+		var numAreas uint32
+		var menuErr, areaErr error
+		entries, menuErr = loadMenuEntriesFromCSV(filepath.Join(opts.Dir, "menu_entries.csv"), opts)
+		areas, numAreas, areaErr = loadAreaEntriesFromCSV(filepath.Join(opts.Dir, "area_entries.csv"), opts)
+		if err := mergeParseErrors(menuErr, areaErr); err != nil {
+			return report, fmt.Errorf("error loading CSV, nothing was written: %w", err)
 		}
-
-		// Write stage IDs
-		stageIdsOffset := currentDataOffset + len(area.entry)*4
-		log.Printf("Writing stage IDs for area %d at offset %d (count: %d)", i, stageIdsOffset, len(area.stageIds))
-		for j, stageId := range area.stageIds {
-			stageIdOffset := stageIdsOffset + j*2
-			if stageIdOffset+2 > len(output) {
-				log.Fatalf("Buffer too small for stage ID %d in area %d (offset %d + 2 > %d)",
-					j, i, stageIdOffset, len(output))
-			}
-			binary.LittleEndian.PutUint16(output[stageIdOffset:], stageId)
+		if int(numAreas) != len(areas) {
+			log.Printf("Warning: last AreaIndex is %d but %d areas were loaded; writing %d", numAreas, len(areas), len(areas))
 		}
-
-		// Add terminating uint16 (0) after stageIds
-		terminatorOffset := stageIdsOffset + len(area.stageIds)*2
-		if terminatorOffset+2 > len(output) {
-			log.Fatalf("Buffer too small for terminator in area %d (offset %d + 2 > %d)",
-				i, terminatorOffset, len(output))
+	case "json":
+		var err error
+		entries, areas, err = loadJSON(filepath.Join(opts.Dir, "mhfjmp.json"), opts)
+		if err != nil {
+			return report, fmt.Errorf("error loading JSON, nothing was written: %w", err)
 		}
-		log.Printf("Writing terminator for area %d at offset %d", i, terminatorOffset)
-		binary.LittleEndian.PutUint16(output[terminatorOffset:], 0)
-
-		// Update current data offset for next area
-		currentDataOffset = terminatorOffset + 2 // Add 2 bytes for the terminator
-		log.Printf("Next area data will start at offset %d", currentDataOffset)
+	default:
+		return report, fmt.Errorf("unknown format '%s', expected 'csv' or 'json'", opts.Format)
 	}
+	log.Printf("Number of entries loaded: %d", len(entries))
+	log.Printf("Number of areas loaded: %d", len(areas))
 
-	// Update header with new offsets
-	binary.LittleEndian.PutUint32(output[0x04:], uint32(areaSectionOffset))
-	binary.LittleEndian.PutUint32(output[0x08:], numAreas)
-
-	err = os.WriteFile("output/mhfjmp_patched.bin", output, 0644)
+	data, err := os.ReadFile(opts.Input)
 	if err != nil {
-		log.Fatalf("Error writing: %v", err)
+		return report, fmt.Errorf("error reading %s: %w", opts.Input, missingInput(err))
 	}
+	report.SourceSize = len(data)
+	log.Printf("Size of %s: %d bytes", opts.Input, len(data))
 
-	fmt.Println("✅ Injection completed in output/mhfjmp_patched.bin")
-}
-
-func encodeShiftJIS(str string) []byte {
-	buf := make([]byte, len(str)*2)
-	n, _, _ := transform.Bytes(japanese.ShiftJIS.NewEncoder(), []byte(str))
-	copy(buf, n)
-	return n
-}
-
-func parseUint32(s string) uint32 {
-	v, err := strconv.ParseUint(s, 10, 32)
+	plain, layers, err := container.Unwrap(data)
 	if err != nil {
-		log.Printf("Error parsing uint32 '%s': %v", s, err)
-		return 0
+		return report, fmt.Errorf("error unwrapping %s: %w", opts.Input, err)
 	}
-	return uint32(v)
-}
-
-func parseUint16(s string) uint16 {
-	v, err := strconv.ParseUint(s, 10, 16)
-	if err != nil {
-		log.Printf("Error parsing uint16 '%s': %v", s, err)
-		return 0
+	report.Layers = layers
+	if len(layers) > 0 {
+		log.Printf("%s is wrapped in %s (%d bytes inside), the output will be too", opts.Input, container.Describe(layers), len(plain))
 	}
-	return uint16(v)
-}
 
-func parseUint8(s string) uint8 {
-	v, err := strconv.ParseUint(s, 10, 8)
+	file, err := jmp.Parse(plain)
 	if err != nil {
-		log.Printf("Error parsing uint8 '%s': %v", s, err)
-		return 0
+		return report, fmt.Errorf("error parsing %s: %w", opts.Input, err)
 	}
-	return uint8(v)
-}
+	file.Menu = entries
+	file.Areas = areas
 
-func parseFloat32(s string) float32 {
-	v, err := strconv.ParseFloat(s, 32)
+	output, layout, err := file.Marshal(opts.MarshalOptions)
 	if err != nil {
-		log.Printf("Error parsing float32 '%s': %v", s, err)
-		return 0
+		return report, fmt.Errorf("error building %s: %w", opts.Output, err)
 	}
-	return float32(v)
-}
+	log.Printf("Layout (%s): menu at 0x%X, text at 0x%X (%d bytes, %d reserved), areas at 0x%X, area data at 0x%X, %d unknown block(s) kept",
+		layout.Mode, layout.MenuOffset, layout.StringOffset, layout.StringSize, layout.StringReserved, layout.AreaOffset,
+		layout.AreaDataOffset, layout.Blobs)
+	if opts.Compact {
+		log.Printf("Compact: dropped %d bytes of tables left by earlier append runs", layout.Dropped)
+	}
+	log.Printf("Text pool: %d strings kept in place, %d new or changed, %d unique, %d sharing a suffix, %d bytes (saved %d bytes)",
+		layout.StringsKept, layout.Strings.Strings, layout.Strings.Unique, layout.Strings.Shared, layout.Strings.Size, layout.Strings.Saved)
+	if len(layers) > 0 {
+		log.Printf("Size before %s: %d bytes (source: %d bytes)", container.Describe(layers), len(output), len(plain))
+		if output, err = container.Wrap(output, layers); err != nil {
+			return report, fmt.Errorf("error wrapping %s: %w", opts.Output, err)
+		}
+	}
+	log.Printf("Total size written: %d bytes (source: %d bytes)", len(output), len(data))
 
-func writeFloat32(b []byte, f float32) {
-	binary.LittleEndian.PutUint32(b, math.Float32bits(f))
-}
+	if err := os.MkdirAll(filepath.Dir(opts.Output), os.ModePerm); err != nil {
+		return report, fmt.Errorf("error creating output directory: %w", err)
+	}
+	if err := os.WriteFile(opts.Output, output, 0644); err != nil {
+		return report, fmt.Errorf("error writing %s: %w", opts.Output, err)
+	}
 
-func Start() {
-	InjectData()
+	report.Entries = len(entries)
+	report.Areas = len(areas)
+	report.Layout = layout
+	report.SourceCRC = crc32.ChecksumIEEE(data)
+	for _, p := range []struct {
+		format patch.Format
+		path   string
+	}{{patch.IPS, opts.IPS}, {patch.BPS, opts.BPS}} {
+		if p.path == "" {
+			continue
+		}
+		diff, err := patch.Create(p.format, data, output)
+		if err != nil {
+			return report, fmt.Errorf("error creating %s patch: %w", strings.ToUpper(string(p.format)), err)
+		}
+		if err := os.MkdirAll(filepath.Dir(p.path), os.ModePerm); err != nil {
+			return report, fmt.Errorf("error creating patch directory: %w", err)
+		}
+		if err := os.WriteFile(p.path, diff, 0644); err != nil {
+			return report, fmt.Errorf("error writing %s: %w", p.path, err)
+		}
+		log.Printf("%s patch written to %s (%d bytes, source CRC32 0x%08X)",
+			strings.ToUpper(string(p.format)), p.path, len(diff), report.SourceCRC)
+		report.Patches = append(report.Patches, p.path)
+	}
+	return report, nil
 }
@@ -0,0 +1,432 @@
+package injector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mhfjmp-editor/container"
+	"mhfjmp-editor/extractor"
+	"mhfjmp-editor/internal/fixture"
+	"mhfjmp-editor/jmp"
+	"mhfjmp-editor/patch"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// extract writes img to a temporary folder and extracts it there in format.
+// It returns the path of the binary and the folder.
+func extract(t *testing.T, img fixture.Image, format string) (input, dir string) {
+	t.Helper()
+	dir = t.TempDir()
+	input = filepath.Join(dir, "mhfjmp.bin")
+	if err := os.WriteFile(input, img.Build(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractor.Extract(extractor.Options{Input: input, OutputDir: dir, Format: format}); err != nil {
+		t.Fatal(err)
+	}
+	return input, dir
+}
+
+func parseFile(t *testing.T, path string) *jmp.File {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := jmp.Parse(data)
+	if err != nil {
+		t.Fatalf("output does not parse: %v", err)
+	}
+	return f
+}
+
+func TestInjectRoundTrip(t *testing.T) {
+	images := fixture.Variants()
+	images["packed"] = fixture.Image{Menu: fixture.Default().Menu, Areas: fixture.Default().Areas}
+	images["single area"] = fixture.Image{Menu: fixture.Default().Menu[:1], Areas: fixture.Default().Areas[:1], Trailer: []byte{1, 2, 3}}
+	tests := []struct {
+		format string
+		mode   jmp.Mode
+	}{
+		{"csv", jmp.ModeRebuild},
+		{"json", jmp.ModeRebuild},
+		{"csv", jmp.ModeAppend},
+		{"json", jmp.ModeAppend},
+	}
+	for name, img := range images {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.format+"/"+tt.mode.String(), func(t *testing.T) {
+				input, dir := extract(t, img, tt.format)
+				opts := Options{Input: input, Dir: dir, Output: filepath.Join(dir, "out.bin"), Format: tt.format}
+				opts.Mode = tt.mode
+				report, err := Inject(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.Entries != len(img.Menu) || report.Areas != len(img.Areas) {
+					t.Errorf("Inject() wrote %d entries and %d areas, want %d and %d",
+						report.Entries, report.Areas, len(img.Menu), len(img.Areas))
+				}
+
+				got := parseFile(t, opts.Output)
+				if !reflect.DeepEqual(got.Menu, img.Menu) {
+					t.Errorf("menu after round trip =\n%+v\nwant\n%+v", got.Menu, img.Menu)
+				}
+				if !reflect.DeepEqual(got.Areas, img.Areas) {
+					t.Errorf("areas after round trip =\n%+v\nwant\n%+v", got.Areas, img.Areas)
+				}
+				if tt.mode != jmp.ModeRebuild {
+					return
+				}
+				original, _ := os.ReadFile(input)
+				output, _ := os.ReadFile(opts.Output)
+				if !bytes.Equal(original, output) {
+					t.Errorf("rebuilt file differs from the original (%d bytes, want %d)", len(output), len(original))
+				}
+			})
+		}
+	}
+}
+
+func TestInjectWrapped(t *testing.T) {
+	img := fixture.Default()
+	layers := []container.Layer{{Kind: container.ECD, Key: 4}, {Kind: container.JKR, Type: container.JKRHFI}}
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mhfjmp.bin")
+	wrapped, err := container.Wrap(img.Build(), layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(input, wrapped, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractor.Extract(extractor.Options{Input: input, OutputDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Input: input, Dir: dir, Output: filepath.Join(dir, "out.bin")}
+	report, err := Inject(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := container.Describe(report.Layers), container.Describe(layers); got != want {
+		t.Errorf("Inject() found layers %s, want %s", got, want)
+	}
+	output, _ := os.ReadFile(opts.Output)
+	plain, got, err := container.Unwrap(output)
+	if err != nil {
+		t.Fatalf("output does not unwrap: %v", err)
+	}
+	if container.Describe(got) != container.Describe(layers) {
+		t.Errorf("output is wrapped in %s, want %s", container.Describe(got), container.Describe(layers))
+	}
+	if !bytes.Equal(plain, img.Build()) {
+		t.Errorf("file inside the output differs from the original (%d bytes, want %d)", len(plain), len(img.Build()))
+	}
+}
+
+func TestInjectEdited(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		old, new string
+		check    func(t *testing.T, f *jmp.File)
+	}{
+		{"longer title", "menu_entries.csv", "ギルド酒場,", "大衆酒場と狩人の集会所,", func(t *testing.T, f *jmp.File) {
+			if got := f.Menu[1].Title; got != "大衆酒場と狩人の集会所" {
+				t.Errorf("Title = %q", got)
+			}
+		}},
+		{"new stage ID", "area_entries.csv", `"100,101"`, `"100,101,102"`, func(t *testing.T, f *jmp.File) {
+			if got := f.Areas[0].StageIDs; !reflect.DeepEqual(got, []uint16{100, 101, 102}) {
+				t.Errorf("StageIDs = %v", got)
+			}
+		}},
+		{"removed entry", "area_entries.csv", `"[1,2] [3,4] "`, `"[3,4]"`, func(t *testing.T, f *jmp.File) {
+			if got := f.Areas[0].Entries; !reflect.DeepEqual(got, []jmp.AreaEntry{{Index: 3, Flags: 4}}) {
+				t.Errorf("Entries = %v", got)
+			}
+		}},
+		{"hex and binary menu", "menu_entries.csv", ",1000,0,1,0,0,", ",0x1F4,0,0b10,0X3,0B1,", func(t *testing.T, f *jmp.File) {
+			if e := f.Menu[0]; e.JumpID != 500 || e.AreaID != 2 || e.AreaID2 != 3 || e.AreaID3 != 1 {
+				t.Errorf("JumpID, AreaIDs = %d, %d, %d, %d, want 500, 2, 3, 1", e.JumpID, e.AreaID, e.AreaID2, e.AreaID3)
+			}
+		}},
+		{"hex and binary areas", "area_entries.csv", `"[1,2] [3,4] ","100,101"`, `"[0x1,0b10] [3,0x8000] ","0x64 0b1100101"`, func(t *testing.T, f *jmp.File) {
+			want := jmp.Area{Entries: []jmp.AreaEntry{{Index: 1, Flags: 2}, {Index: 3, Flags: 0x8000}}, StageIDs: []uint16{100, 101}}
+			if !reflect.DeepEqual(f.Areas[0], want) {
+				t.Errorf("area 1 = %+v, want %+v", f.Areas[0], want)
+			}
+		}},
+		{"new area", "area_entries.csv", "4,\"[7,8] [9,10] \",\n", "4,\"[7,8] [9,10] \",\n5,\"[1,1]\",500\n", func(t *testing.T, f *jmp.File) {
+			if len(f.Areas) != 5 || !reflect.DeepEqual(f.Areas[4].StageIDs, []uint16{500}) {
+				t.Errorf("Areas = %+v", f.Areas)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		for _, mode := range []jmp.Mode{jmp.ModeRebuild, jmp.ModeAppend} {
+			t.Run(tt.name+"/"+mode.String(), func(t *testing.T) {
+				input, dir := extract(t, fixture.Default(), "csv")
+				editFile(t, filepath.Join(dir, tt.file), tt.old, tt.new)
+				opts := Options{Input: input, Dir: dir, Output: filepath.Join(dir, "out.bin")}
+				opts.Mode = mode
+				if _, err := Inject(opts); err != nil {
+					t.Fatal(err)
+				}
+				f := parseFile(t, opts.Output)
+				tt.check(t, f)
+				if !bytes.Equal(f.Blobs[len(f.Blobs)-1].Data, []byte("TRAILER")) && mode == jmp.ModeRebuild {
+					t.Errorf("trailer not kept, last block is %q", f.Blobs[len(f.Blobs)-1].Data)
+				}
+			})
+		}
+	}
+}
+
+// A menu CSV with only its header removes every entry; text the source
+// image left unused must not come back as one.
+func TestInjectEmptyMenu(t *testing.T) {
+	for _, mode := range []jmp.Mode{jmp.ModeRebuild, jmp.ModeAppend} {
+		t.Run(mode.String(), func(t *testing.T) {
+			input, dir := extract(t, fixture.Variants()["unused strings"], "csv")
+			path := filepath.Join(dir, "menu_entries.csv")
+			data, _ := os.ReadFile(path)
+			header, _, _ := strings.Cut(string(data), "\n")
+			if err := os.WriteFile(path, []byte(header+"\n"), 0666); err != nil {
+				t.Fatal(err)
+			}
+			opts := Options{Input: input, Dir: dir, Output: filepath.Join(dir, "out.bin")}
+			opts.Mode = mode
+			if _, err := Inject(opts); err != nil {
+				t.Fatal(err)
+			}
+			f := parseFile(t, opts.Output)
+			if len(f.Menu) != 0 || !reflect.DeepEqual(f.Areas, fixture.Default().Areas) {
+				t.Errorf("output holds %d entries and areas %+v, want none and the fixture areas", len(f.Menu), f.Areas)
+			}
+		})
+	}
+}
+
+// Area and stage names are looked up in their own tables, a stage name is
+// not an AreaID.
+func TestInjectNames(t *testing.T) {
+	input, dir := extract(t, fixture.Default(), "csv")
+	editFile(t, filepath.Join(dir, "menu_entries.csv"), ",1000,0,1,", ",1000,0,guild hall,")
+	editFile(t, filepath.Join(dir, "area_entries.csv"), `"100,101"`, `"Town Gate,101"`)
+	opts := Options{Input: input, Dir: dir, Output: filepath.Join(dir, "out.bin"), Names: testNames(t)}
+	if _, err := Inject(opts); err != nil {
+		t.Fatal(err)
+	}
+	f := parseFile(t, opts.Output)
+	if f.Menu[0].AreaID != 2 || !reflect.DeepEqual(f.Areas[0].StageIDs, []uint16{100, 101}) {
+		t.Errorf("AreaID = %d, StageIDs = %v, want 2 and [100 101]", f.Menu[0].AreaID, f.Areas[0].StageIDs)
+	}
+
+	editFile(t, filepath.Join(dir, "menu_entries.csv"), ",guild hall,", ",Town Gate,")
+	if _, err := Inject(opts); !errors.Is(err, ErrMalformedInput) {
+		t.Errorf("Inject() with a stage name as AreaID: error = %v, want ErrMalformedInput", err)
+	}
+}
+
+func TestInjectCompact(t *testing.T) {
+	input, dir := extract(t, fixture.Default(), "csv")
+	opts := Options{Input: input, Dir: dir}
+	opts.Mode = jmp.ModeAppend
+	for i := 0; i < 2; i++ {
+		opts.Output = filepath.Join(dir, fmt.Sprintf("appended%d.bin", i))
+		if _, err := Inject(opts); err != nil {
+			t.Fatal(err)
+		}
+		opts.Input = opts.Output
+	}
+	opts.Output = filepath.Join(dir, "out.bin")
+	opts.Mode = jmp.ModeRebuild
+	opts.Compact = true
+	report, err := Inject(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, _ := os.ReadFile(input)
+	if report.Layout.Dropped == 0 || report.Layout.Size > len(original)+4 {
+		t.Errorf("compacted to %d bytes, %d dropped, want about %d", report.Layout.Size, report.Layout.Dropped, len(original))
+	}
+	if f := parseFile(t, opts.Output); !reflect.DeepEqual(f.Menu, fixture.Default().Menu) {
+		t.Errorf("menu after compacting =\n%+v", f.Menu)
+	}
+}
+
+// Patches go to folders that may not exist yet. When one cannot be written
+// the report still describes the output, which is written first.
+func TestInjectPatches(t *testing.T) {
+	input, dir := extract(t, fixture.Default(), "csv")
+	editFile(t, filepath.Join(dir, "menu_entries.csv"), "ギルド酒場,", "大衆酒場,")
+	opts := Options{Input: input, Dir: dir, Output: filepath.Join(dir, "out.bin"),
+		IPS: filepath.Join(dir, "patches", "ips", "mhfjmp.ips"), BPS: filepath.Join(dir, "patches", "bps", "mhfjmp.bps")}
+	report, err := Inject(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, _ := os.ReadFile(input)
+	output, _ := os.ReadFile(opts.Output)
+	if !reflect.DeepEqual(report.Patches, []string{opts.IPS, opts.BPS}) {
+		t.Errorf("Patches = %q", report.Patches)
+	}
+	for _, path := range report.Patches {
+		diff, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := patch.Apply(source, diff, report.SourceCRC)
+		if err != nil || !bytes.Equal(got, output) {
+			t.Errorf("applying %s: %d bytes, error %v, want the %d bytes of the output", path, len(got), err, len(output))
+		}
+	}
+
+	// A folder where the patch should go cannot be written over
+	os.Remove(opts.Output)
+	opts.BPS = t.TempDir()
+	report, err = Inject(opts)
+	if err == nil {
+		t.Fatal("Inject() writing a patch over a folder succeeded")
+	}
+	if _, err := os.Stat(opts.Output); err != nil {
+		t.Errorf("output not written: %v", err)
+	}
+	if report.Entries != 4 || report.Areas != 4 || report.Layout.Size != len(output) {
+		t.Errorf("report = %d entries, %d areas, %d bytes, want 4, 4 and %d", report.Entries, report.Areas, report.Layout.Size, len(output))
+	}
+}
+
+func TestInjectErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		old, new string
+		input    string
+		want     error
+	}{
+		{name: "missing binary", input: "missing.bin", want: ErrMissingInput},
+		{name: "missing CSV", file: "menu_entries.csv", want: ErrMissingInput},
+		{name: "bad number", file: "menu_entries.csv", old: ",1001,", new: ",10x1,", want: ErrMalformedInput},
+		{name: "bad area entry", file: "area_entries.csv", old: "[5,32768]", new: "[5,32768,1]", want: ErrMalformedInput},
+		{name: "AreaID overflow", file: "menu_entries.csv", old: ",1000,0,1,", new: ",1000,0,0x10000,", want: ErrMalformedInput},
+		{name: "Index overflow", file: "area_entries.csv", old: "[5,32768]", new: "[0x10000,32768]", want: ErrMalformedInput},
+		{name: "Flags overflow", file: "area_entries.csv", old: "[5,32768]", new: "[5,0b10000000000000000]", want: ErrMalformedInput},
+		{name: "stage ID overflow", file: "area_entries.csv", old: `"100,101"`, new: `"100,0x10000"`, want: ErrMalformedInput},
+		{name: "bad binary", file: "menu_entries.csv", old: ",1001,", new: ",0b102,", want: ErrMalformedInput},
+		{name: "zero stage ID", file: "area_entries.csv", old: `"100,101"`, new: `"100,0,101"`, want: jmp.ErrZeroStageID},
+		{name: "unencodable", file: "menu_entries.csv", old: "マイハウス,", new: "マイハウス🏠,", want: ErrEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, dir := extract(t, fixture.Default(), "csv")
+			if tt.input != "" {
+				input = filepath.Join(dir, tt.input)
+			}
+			if tt.file != "" && tt.old == "" {
+				os.Remove(filepath.Join(dir, tt.file))
+			} else if tt.file != "" {
+				editFile(t, filepath.Join(dir, tt.file), tt.old, tt.new)
+			}
+			output := filepath.Join(dir, "out.bin")
+			_, err := Inject(Options{Input: input, Dir: dir, Output: output})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Inject() error = %v, want %v", err, tt.want)
+			}
+			if _, err := os.Stat(output); err == nil {
+				t.Error("Inject() failed but wrote the output")
+			}
+		})
+	}
+}
+
+// Every bad cell of both files is collected into one report.
+func TestInjectParseErrors(t *testing.T) {
+	edit := func(t *testing.T, dir string) {
+		menu, areas := filepath.Join(dir, "menu_entries.csv"), filepath.Join(dir, "area_entries.csv")
+		editFile(t, menu, ",1001,", ",10x1,")
+		editFile(t, menu, ",4.5,", ",4.5.1,")
+		editFile(t, areas, `"100,101"`, `"100,0,101"`)
+		editFile(t, areas, "[5,32768]", "[5,0x10000]")
+		editFile(t, areas, "4,\"[7,8] [9,10] \",\n", "4,\"[7,8] [9,10] \",\n5,\"[1,1]\"\n")
+	}
+	want := []struct {
+		file   string
+		line   int
+		column string
+		value  string
+	}{
+		{"menu_entries.csv", 3, "JumpID", "10x1"},
+		{"menu_entries.csv", 5, "PosX", "4.5.1"},
+		{"area_entries.csv", 2, "StageIds", "100,0,101"},
+		{"area_entries.csv", 3, "AreaEntries", "[5,0x10000] "},
+		{"area_entries.csv", 6, "", ""},
+	}
+
+	input, dir := extract(t, fixture.Default(), "csv")
+	edit(t, dir)
+	output := filepath.Join(dir, "out.bin")
+	_, err := Inject(Options{Input: input, Dir: dir, Output: output})
+	var pe ParseErrors
+	if !errors.Is(err, ErrMalformedInput) || !errors.As(err, &pe) {
+		t.Fatalf("Inject() error = %v, want ParseErrors", err)
+	}
+	if len(pe) != len(want) {
+		t.Fatalf("Inject() error = %v, want %d problems", err, len(want))
+	}
+	for i, fe := range pe {
+		w := want[i]
+		if filepath.Base(fe.File) != w.file || fe.Line != w.line || fe.Column != w.column || fe.Value != w.value {
+			t.Errorf("problem %d = %v, want %s:%d column %q value %q", i, fe, w.file, w.line, w.column, w.value)
+		}
+	}
+	if !strings.HasPrefix(err.Error(), "error loading CSV, nothing was written: 5 problem(s) in input:\n  ") {
+		t.Errorf("Inject() error =\n%v", err)
+	}
+	if _, err := os.Stat(output); err == nil {
+		t.Error("Inject() failed but wrote the output")
+	}
+
+	// Lenient writes 0 for bad numbers, leaves out bad pairs and stage IDs
+	// and skips short rows
+	input, dir = extract(t, fixture.Default(), "csv")
+	edit(t, dir)
+	opts := Options{Input: input, Dir: dir, Output: output, Lenient: true}
+	if _, err := Inject(opts); err != nil {
+		t.Fatal(err)
+	}
+	f := parseFile(t, output)
+	if f.Menu[1].JumpID != 0 || f.Menu[3].PosX != 0 || f.Menu[2].JumpID != 1002 {
+		t.Errorf("JumpIDs %d and %d, PosX %v, want 0, 1002 and 0", f.Menu[1].JumpID, f.Menu[2].JumpID, f.Menu[3].PosX)
+	}
+	if len(f.Areas) != 4 || !reflect.DeepEqual(f.Areas[0].StageIDs, []uint16{100, 101}) || f.Areas[1].Entries != nil {
+		t.Errorf("areas = %+v, want 4 with stage IDs [100 101] and no entries in area 2", f.Areas)
+	}
+}
+
+func editFile(t *testing.T, path, old, new string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), old) {
+		t.Fatalf("%s does not contain %q:\n%s", path, old, data)
+	}
+	if err := os.WriteFile(path, []byte(strings.Replace(string(data), old, new, 1)), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+package injector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mhfjmp-editor/jmp"
+	"os"
+	"strings"
+)
+
+// loadJSON reads the menu entries and areas from a file written by the
+// extractor's JSON format. Titles and descriptions go through the same
+// encoding policy as CSV text.
+func loadJSON(path string, opts Options) ([]jmp.MenuEntry, []jmp.Area, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, missingInput(err)
+	}
+
+	var model jmp.File
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if !opts.Lenient {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&model); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !opts.Lenient || !errors.As(err, &typeErr) {
+			return nil, nil, jsonError(path, data, dec.InputOffset(), err)
+		}
+		// Decode keeps going after a type error, the field is left at 0
+		log.Printf("Warning: %v", jsonError(path, data, dec.InputOffset(), err))
+	}
+
+	p := newCSVParser(path, opts)
+	for i := range model.Menu {
+		entry := &model.Menu[i]
+		entry.Title = p.text(fmt.Sprintf("menu[%d].title", i), entry.Title)
+		entry.Description = p.text(fmt.Sprintf("menu[%d].description", i), entry.Description)
+	}
+	// A 0 would end the stage ID list, drop it like parseStageIds does
+	for i := range model.Areas {
+		area := &model.Areas[i]
+		ids := area.StageIDs[:0]
+		for j, id := range area.StageIDs {
+			if id == 0 {
+				p.fail(fmt.Sprintf("areas[%d].stageIds[%d]", i, j), "0", errZeroStageID)
+				continue
+			}
+			ids = append(ids, id)
+		}
+		area.StageIDs = ids
+	}
+	return model.Menu, model.Areas, p.Err()
+}
+
+// jsonError turns a decoding error into a FieldError pointing at the line
+// it occurred on.
+func jsonError(path string, data []byte, offset int64, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		offset = int64(len(data))
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		err = fmt.Errorf("%s: got JSON %s, expected %s", typeErr.Field, typeErr.Value, typeErr.Type)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The decoder does not say where, use the first occurrence of the key
+		key := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		if i := bytes.Index(data, []byte(key)); i >= 0 {
+			offset = int64(i)
+		}
+	}
+	return ParseErrors{{File: path, Line: lineAt(data, offset), Err: err}}
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
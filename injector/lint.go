@@ -0,0 +1,143 @@
+package injector
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Severity ranks a lint Finding. Errors make Lint fail, warnings are things
+// the game accepts but are probably mistakes.
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Finding is one problem Lint found in the CSV files.
+type Finding struct {
+	Severity Severity
+	File     string
+	Line     int
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", f.File, f.Line, f.Severity, f.Message)
+}
+
+// Lint loads the CSV files in opts.Dir the way Inject does and checks them
+// for problems Inject does not catch: duplicate JumpIDs, AreaIDs without a
+// matching area, and so on. Invalid values, such as a 0 in a stage ID list
+// or an old lenEntryData column disagreeing with the entry list, are reported
+// as errors too. The error is only set when the files could not be read at all.
+func Lint(opts Options) ([]Finding, error) {
+	if opts.Dir == "" {
+		opts.Dir = DefaultDir
+	}
+	menuPath := filepath.Join(opts.Dir, "menu_entries.csv")
+	areaPath := filepath.Join(opts.Dir, "area_entries.csv")
+
+	var findings []Finding
+	report := func(sev Severity, file string, line int, format string, args ...any) {
+		findings = append(findings, Finding{Severity: sev, File: file, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+	// Bad values are errors, the checks below then see them as 0
+	addParseErrors := func(err error) error {
+		var pe ParseErrors
+		if err != nil && !errors.As(err, &pe) {
+			return err
+		}
+		for _, fe := range pe {
+			msg := strings.ReplaceAll(fe.Err.Error(), "\n", "; ")
+			if fe.Column != "" {
+				msg = fmt.Sprintf("column %s: invalid value '%s': %s", fe.Column, fe.Value, msg)
+			}
+			report(Error, fe.File, fe.Line, "%s", msg)
+		}
+		return nil
+	}
+
+	opts.Lenient = false
+	menu, err := readMenuRows(menuPath, opts)
+	if err := addParseErrors(err); err != nil {
+		return nil, err
+	}
+	areas, err := readAreaRows(areaPath, opts)
+	// Without its header the area file says nothing about which areas exist
+	haveAreas := areas != nil || err == nil
+	if err := addParseErrors(err); err != nil {
+		return nil, err
+	}
+
+	// Menu entries
+	jumpIDs := make(map[uint32]int)
+	for _, row := range menu {
+		e := row.entry
+		if prev, ok := jumpIDs[e.JumpID]; ok {
+			report(Error, menuPath, row.line, "duplicate JumpID %d, already used on line %d", e.JumpID, prev)
+		} else {
+			jumpIDs[e.JumpID] = row.line
+		}
+		if e.AreaID == 0 {
+			report(Warning, menuPath, row.line, "AreaID is 0")
+		} else if haveAreas && int(e.AreaID) > len(areas) {
+			// Areas are written in row order, whatever their AreaIndex
+			report(Error, menuPath, row.line, "AreaID %d does not exist, area_entries.csv has %d areas", e.AreaID, len(areas))
+		}
+		for _, id := range []struct {
+			name  string
+			value uint16
+		}{{"AreaID2", e.AreaID2}, {"AreaID3", e.AreaID3}} {
+			if haveAreas && int(id.value) > len(areas) {
+				report(Warning, menuPath, row.line, "%s %d does not exist, area_entries.csv has %d areas", id.name, id.value, len(areas))
+			}
+		}
+		if strings.TrimSpace(e.Title) == "" {
+			report(Warning, menuPath, row.line, "Title is empty")
+		}
+	}
+
+	// Areas
+	for i, row := range areas {
+		if row.index != uint32(i+1) {
+			report(Warning, areaPath, row.line, "AreaIndex is %d but this is area %d, areas are written in row order", row.index, i+1)
+		}
+		indexes := make(map[uint16]bool)
+		for _, entry := range row.area.Entries {
+			if indexes[entry.Index] {
+				report(Warning, areaPath, row.line, "AreaEntries lists Index %d more than once", entry.Index)
+			}
+			indexes[entry.Index] = true
+		}
+		stages := make(map[uint16]bool)
+		// A 0 is already reported as an invalid value
+		for _, id := range row.area.StageIDs {
+			if stages[id] {
+				report(Warning, areaPath, row.line, "stage ID %d is listed more than once", id)
+			}
+			stages[id] = true
+		}
+		if len(row.area.Entries) == 0 && len(row.area.StageIDs) == 0 {
+			report(Warning, areaPath, row.line, "area has no entries and no stage IDs")
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File == menuPath
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return findings, nil
+}
@@ -0,0 +1,107 @@
+package injector
+
+import (
+	"mhfjmp-editor/internal/fixture"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	type want struct {
+		severity Severity
+		file     string
+		line     int
+		message  string
+	}
+	tests := []struct {
+		name string
+		file string
+		// old is replaced by new; an empty old replaces the whole file
+		old, new string
+		want     []want
+	}{
+		{name: "clean"},
+		{"duplicate JumpID", "menu_entries.csv", ",1001,", ",1000,", []want{
+			{Error, "menu_entries.csv", 3, "duplicate JumpID 1000, already used on line 2"},
+		}},
+		{"unknown AreaID", "menu_entries.csv", ",1000,0,1,0,0,", ",1000,0,9,0,0,", []want{
+			{Error, "menu_entries.csv", 2, "AreaID 9 does not exist, area_entries.csv has 4 areas"},
+		}},
+		{"lenEntryData", "area_entries.csv", "", "AreaIndex,AreaEntries,lenEntryData,StageIds\n" +
+			"1,\"[1,2] [3,4] \",2,\"100,101\"\n" +
+			"2,\"[5,32768] \",3,200\n" +
+			"3,,0,\"300,301,302\"\n" +
+			"4,\"[7,8] [9,10] \",2,\n", []want{
+			{Error, "area_entries.csv", 3, "column lenEntryData: invalid value '3'"},
+		}},
+		{"zero stage ID", "area_entries.csv", `"100,101"`, `"100,0,101"`, []want{
+			{Error, "area_entries.csv", 2, "column StageIds: invalid value '100,0,101'"},
+		}},
+		{"bad value", "menu_entries.csv", ",1001,", ",10x1,", []want{
+			{Error, "menu_entries.csv", 3, "column JumpID: invalid value '10x1'"},
+		}},
+		{"AreaID 0", "menu_entries.csv", ",1000,0,1,0,0,", ",1000,0,0,0,0,", []want{
+			{Warning, "menu_entries.csv", 2, "AreaID is 0"},
+		}},
+		{"unknown AreaID2", "menu_entries.csv", ",1001,1,2,1,0,", ",1001,1,2,9,0,", []want{
+			{Warning, "menu_entries.csv", 3, "AreaID2 9 does not exist"},
+		}},
+		{"unknown AreaID3", "menu_entries.csv", ",1001,1,2,1,0,", ",1001,1,2,1,9,", []want{
+			{Warning, "menu_entries.csv", 3, "AreaID3 9 does not exist"},
+		}},
+		{"empty title", "menu_entries.csv", "3,Rasta Bar,", "3, ,", []want{
+			{Warning, "menu_entries.csv", 5, "Title is empty"},
+		}},
+		// AreaID 3 is the third row whatever its AreaIndex
+		{"AreaIndex out of order", "area_entries.csv", `3,,"300`, `7,,"300`, []want{
+			{Warning, "area_entries.csv", 4, "AreaIndex is 7 but this is area 3"},
+		}},
+		{"AreaID past the last row", "area_entries.csv", "3,,\"300,301,302\"\n4,\"[7,8] [9,10] \",\n", "", []want{
+			{Error, "menu_entries.csv", 4, "AreaID 3 does not exist, area_entries.csv has 2 areas"},
+		}},
+		{"repeated Index", "area_entries.csv", `"[1,2] [3,4] "`, `"[1,2] [1,4] "`, []want{
+			{Warning, "area_entries.csv", 2, "AreaEntries lists Index 1 more than once"},
+		}},
+		{"repeated stage ID", "area_entries.csv", `"100,101"`, `"100,100"`, []want{
+			{Warning, "area_entries.csv", 2, "stage ID 100 is listed more than once"},
+		}},
+		{"empty area", "area_entries.csv", `3,,"300,301,302"`, `3,,`, []want{
+			{Warning, "area_entries.csv", 4, "area has no entries and no stage IDs"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, dir := extract(t, fixture.Default(), "csv")
+			if tt.file != "" && tt.old == "" {
+				if err := os.WriteFile(filepath.Join(dir, tt.file), []byte(tt.new), 0666); err != nil {
+					t.Fatal(err)
+				}
+			} else if tt.file != "" {
+				editFile(t, filepath.Join(dir, tt.file), tt.old, tt.new)
+			}
+			findings, err := Lint(Options{Dir: dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(findings) != len(tt.want) {
+				t.Fatalf("Lint() = %v, want %d finding(s)", findings, len(tt.want))
+			}
+			for i, f := range findings {
+				w := tt.want[i]
+				if f.Severity != w.severity || filepath.Base(f.File) != w.file || f.Line != w.line || !strings.Contains(f.Message, w.message) {
+					t.Errorf("finding %d = %v, want %s:%d: %s: %s...", i, f, w.file, w.line, w.severity, w.message)
+				}
+			}
+		})
+	}
+}
+
+func TestLintMissingFile(t *testing.T) {
+	_, dir := extract(t, fixture.Default(), "csv")
+	os.Remove(filepath.Join(dir, "area_entries.csv"))
+	if _, err := Lint(Options{Dir: dir}); err == nil {
+		t.Error("Lint() without area_entries.csv: error = nil")
+	}
+}
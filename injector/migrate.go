@@ -0,0 +1,87 @@
+package injector
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// MigrateAreaCSV removes the lenEntryData column from an area_entries.csv
+// written by an older extractor; the count is now derived from AreaEntries.
+// The original file is kept as path+".bak". Rows where the column disagreed
+// with the pairs are listed in notes: the pairs are what Inject writes, so
+// they are kept. changed is false if the file has no such column.
+func MigrateAreaCSV(path string) (notes []string, changed bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, missingInput(err)
+	}
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	var records [][]string
+	var lines []int
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, false, csvError(path, err)
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, rec)
+		lines = append(lines, line)
+	}
+	file.Close()
+	if len(records) == 0 {
+		return nil, false, nil
+	}
+
+	column, entries := -1, -1
+	for i, name := range records[0] {
+		switch strings.TrimSpace(name) {
+		case "lenEntryData":
+			column = i
+		case "AreaEntries":
+			entries = i
+		}
+	}
+	if column < 0 {
+		return nil, false, nil
+	}
+
+	for i, rec := range records {
+		if i > 0 && column < len(rec) && entries >= 0 && entries < len(rec) {
+			pairs, err := parseAreaEntries(rec[entries])
+			n, nErr := parseUint32(rec[column])
+			if err == nil && (nErr != nil || int(n) != len(pairs)) {
+				notes = append(notes, fmt.Sprintf("%s:%d: lenEntryData was '%s' but AreaEntries has %d [Index,Flags] pairs, the pairs are kept",
+					path, lines[i], rec[column], len(pairs)))
+			}
+		}
+		if column < len(rec) {
+			records[i] = append(rec[:column:column], rec[column+1:]...)
+		}
+	}
+
+	if err := os.Rename(path, path+".bak"); err != nil {
+		return nil, false, err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return nil, false, err
+	}
+	writer := csv.NewWriter(out)
+	writer.WriteAll(records)
+	if err := writer.Error(); err != nil {
+		out.Close()
+		return nil, false, fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, false, err
+	}
+	return notes, true, nil
+}
@@ -0,0 +1,91 @@
+package injector
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const oldAreaCSV = "AreaIndex,AreaEntries,lenEntryData,StageIds\n" +
+	"1,\"[1,2] [3,4] \",2,\"100,101\"\n" +
+	"2,\"[5,32768] \",3,200\n" +
+	"3,,0,\"300,301,302\"\n" +
+	"4,\"[7,8] [9,10] \",x,\n"
+
+func TestMigrateAreaCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "area_entries.csv")
+	if err := os.WriteFile(path, []byte(oldAreaCSV), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	notes, changed, err := MigrateAreaCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("MigrateAreaCSV() changed = false, want true")
+	}
+	if len(notes) != 2 || !strings.Contains(notes[0], ":3: lenEntryData was '3'") || !strings.Contains(notes[1], ":5: lenEntryData was 'x'") {
+		t.Errorf("notes = %q, want lines 3 and 5", notes)
+	}
+	backup, err := os.ReadFile(path + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(backup, []byte(oldAreaCSV)) {
+		t.Errorf("backup =\n%s\nwant\n%s", backup, oldAreaCSV)
+	}
+	want := "AreaIndex,AreaEntries,StageIds\n" +
+		"1,\"[1,2] [3,4] \",\"100,101\"\n" +
+		"2,\"[5,32768] \",200\n" +
+		"3,,\"300,301,302\"\n" +
+		"4,\"[7,8] [9,10] \",\n"
+	migrated, _ := os.ReadFile(path)
+	if string(migrated) != want {
+		t.Errorf("migrated file =\n%s\nwant\n%s", migrated, want)
+	}
+	rows, err := readAreaRows(path, Options{})
+	if err != nil {
+		t.Fatalf("migrated file does not load: %v", err)
+	}
+	if got := rows[1].area.Entries; len(got) != 1 || got[0].Index != 5 || got[0].Flags != 0x8000 {
+		t.Errorf("area 2 entries = %v, want the pairs kept", got)
+	}
+
+	// A second run finds nothing to do and keeps the first backup
+	notes, changed, err = MigrateAreaCSV(path)
+	if err != nil || changed || notes != nil {
+		t.Errorf("second MigrateAreaCSV() = %q, %v, %v, want nothing done", notes, changed, err)
+	}
+	if again, _ := os.ReadFile(path); !bytes.Equal(again, migrated) {
+		t.Errorf("second run rewrote the file:\n%s", again)
+	}
+	if again, _ := os.ReadFile(path + ".bak"); !bytes.Equal(again, backup) {
+		t.Error("second run overwrote the backup")
+	}
+}
+
+func TestMigrateAreaCSVCurrent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "area_entries.csv")
+	current := "AreaIndex,AreaEntries,StageIds\n1,\"[1,2] \",100\n"
+	if err := os.WriteFile(path, []byte(current), 0666); err != nil {
+		t.Fatal(err)
+	}
+	notes, changed, err := MigrateAreaCSV(path)
+	if err != nil || changed || notes != nil {
+		t.Errorf("MigrateAreaCSV() = %q, %v, %v, want nothing done", notes, changed, err)
+	}
+	if _, err := os.Stat(path + ".bak"); err == nil {
+		t.Error("MigrateAreaCSV() wrote a backup of an up to date file")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("folder holds %d files, want 1", len(entries))
+	}
+	if data, _ := os.ReadFile(path); string(data) != current {
+		t.Errorf("file changed to\n%s", data)
+	}
+}
@@ -0,0 +1,280 @@
+package injector
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mhfjmp-editor/internal/number"
+	"mhfjmp-editor/names"
+	"strconv"
+	"strings"
+)
+
+// FieldError is a CSV value that could not be used. For JSON input Line may
+// be 0 and Column is then the path of the value, e.g. menu[3].title.
+type FieldError struct {
+	File   string
+	Line   int
+	Column string
+	Value  string
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	// Keep one line per problem when Err joins several errors
+	msg := strings.ReplaceAll(e.Err.Error(), "\n", "; ")
+	if e.Column == "" {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, msg)
+	}
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s: invalid value '%s': %s", e.File, e.Column, e.Value, msg)
+	}
+	return fmt.Sprintf("%s:%d: column %s: invalid value '%s': %s", e.File, e.Line, e.Column, e.Value, msg)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors is every problem found while loading the CSV or JSON input.
+type ParseErrors []*FieldError
+
+func (e ParseErrors) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d problem(s) in input:", len(e))
+	for _, fe := range e {
+		sb.WriteString("\n  ")
+		sb.WriteString(fe.Error())
+	}
+	return sb.String()
+}
+
+// mergeParseErrors combines the errors of several loaders into one
+// ParseErrors. Any other error is returned as is.
+func mergeParseErrors(errs ...error) error {
+	var all ParseErrors
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		var pe ParseErrors
+		if !errors.As(err, &pe) {
+			return err
+		}
+		all = append(all, pe...)
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	return all
+}
+
+// csvParser converts the cells of one CSV file (or the text of a JSON file).
+// In strict mode every bad value is recorded and reported by Err; otherwise
+// it is logged and replaced by 0.
+type csvParser struct {
+	file    string
+	strict  bool
+	encoder textEncoder
+	areas   *names.Table
+	line    int
+	errs    ParseErrors
+}
+
+func newCSVParser(file string, opts Options) *csvParser {
+	replacements := opts.Replacements
+	if replacements == nil {
+		replacements = DefaultReplacements
+	}
+	return &csvParser{
+		file:    file,
+		strict:  !opts.Lenient,
+		encoder: textEncoder{policy: opts.Encoding, replacements: replacements},
+		areas:   opts.Names.Table(names.Area),
+	}
+}
+
+func (p *csvParser) fail(column, value string, err error) {
+	if p.strict {
+		p.errs = append(p.errs, &FieldError{File: p.file, Line: p.line, Column: column, Value: value, Err: err})
+		return
+	}
+	if column == "" {
+		log.Printf("Warning: %s line %d: %v", p.file, p.line, err)
+		return
+	}
+	log.Printf("Warning: %s line %d: error parsing %s '%s': %v", p.file, p.line, column, value, err)
+}
+
+func (p *csvParser) Err() error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return p.errs
+}
+
+// text applies the encoding policy to a title or description. Text that
+// cannot be encoded is an error even in lenient mode.
+func (p *csvParser) text(column, s string) string {
+	out, changed, err := p.encoder.apply(s)
+	for _, c := range changed {
+		log.Printf("%s line %d: %s: %s", p.file, p.line, column, c)
+	}
+	if err != nil {
+		p.errs = append(p.errs, &FieldError{File: p.file, Line: p.line, Column: column, Value: s, Err: err})
+	}
+	return out
+}
+
+func (p *csvParser) uint32(column, s string) uint32 {
+	v, err := parseUint32(s)
+	if err != nil {
+		p.fail(column, s, err)
+	}
+	return v
+}
+
+func (p *csvParser) uint16(column, s string) uint16 {
+	v, err := parseUint16(s)
+	if err != nil {
+		p.fail(column, s, err)
+	}
+	return v
+}
+
+// areaID parses an AreaID written as a number or, with a name mapping, as
+// the name of an area.
+func (p *csvParser) areaID(column, s string) uint16 {
+	v, err := parseID(s, p.areas)
+	if err != nil {
+		p.fail(column, s, err)
+	}
+	return v
+}
+
+func (p *csvParser) float32(column, s string) float32 {
+	v, err := parseFloat32(s)
+	if err != nil {
+		p.fail(column, s, err)
+	}
+	return v
+}
+
+// csvTable is a CSV file whose columns are looked up by header name.
+type csvTable struct {
+	reader  *csv.Reader
+	columns map[string]int
+	width   int
+}
+
+// readCSVTable reads the header row and checks that every required column is
+// present.
+func readCSVTable(r io.Reader, p *csvParser, required []string) (*csvTable, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, ParseErrors{{File: p.file, Line: 1, Err: errors.New("file is empty")}}
+	}
+	if err != nil {
+		return nil, csvError(p.file, err)
+	}
+
+	t := &csvTable{reader: reader, columns: make(map[string]int), width: len(header)}
+	for i, name := range header {
+		t.columns[strings.TrimSpace(name)] = i
+	}
+	// A missing column is an error even in lenient mode
+	var missing ParseErrors
+	for _, name := range required {
+		if _, ok := t.columns[name]; !ok {
+			missing = append(missing, &FieldError{File: p.file, Line: 1, Err: fmt.Errorf("missing column %s", name)})
+		}
+	}
+	if len(missing) > 0 {
+		return nil, missing
+	}
+	return t, nil
+}
+
+// next returns the next record, or nil at the end of the file. Records with
+// fewer columns than the header are reported and skipped.
+func (t *csvTable) next(p *csvParser) ([]string, error) {
+	for {
+		rec, err := t.reader.Read()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, csvError(p.file, err)
+		}
+		p.line, _ = t.reader.FieldPos(0)
+		if len(rec) < t.width {
+			p.fail("", "", fmt.Errorf("line has %d columns, expected %d", len(rec), t.width))
+			continue
+		}
+		return rec, nil
+	}
+}
+
+// csvError reports a CSV syntax error, such as a stray quote, as a
+// ParseErrors so it matches ErrMalformedInput.
+func csvError(file string, err error) error {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return ParseErrors{{File: file, Line: pe.Line, Err: fmt.Errorf("column %d: %w", pe.Column, pe.Err)}}
+	}
+	return fmt.Errorf("%s: %w", file, err)
+}
+
+func (t *csvTable) has(column string) bool {
+	_, ok := t.columns[column]
+	return ok
+}
+
+func (t *csvTable) get(rec []string, column string) string {
+	return rec[t.columns[column]]
+}
+
+// parseID parses a stage or area ID written as a number or as a name of
+// table, which may be nil.
+func parseID(s string, table *names.Table) (uint16, error) {
+	v, err := parseUint16(s)
+	if err == nil || table == nil {
+		return v, err
+	}
+	if id, ok := table.ID(s); ok {
+		return id, nil
+	}
+	return 0, errors.New("not a number or a known name")
+}
+
+func parseUint32(s string) (uint32, error) {
+	v, err := number.ParseUint(s, 32)
+	return uint32(v), err
+}
+
+func parseUint16(s string) (uint16, error) {
+	v, err := number.ParseUint(s, 16)
+	return uint16(v), err
+}
+
+func parseFloat32(s string) (float32, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 32)
+	if err != nil {
+		return 0, unwrapNumError(err)
+	}
+	return float32(v), nil
+}
+
+// unwrapNumError drops the "strconv.ParseFloat: parsing ..." prefix, the
+// value is already part of FieldError.
+func unwrapNumError(err error) error {
+	var ne *strconv.NumError
+	if errors.As(err, &ne) {
+		return ne.Err
+	}
+	return err
+}
@@ -0,0 +1,229 @@
+// Package fixture builds synthetic mhfjmp.bin images for tests, so that no
+// client file has to be committed. The images are laid out the way the
+// client files are: header, filler, menu table, Shift-JIS string pool, area
+// table, area data and trailing bytes. The layout is written out by hand
+// rather than with jmp.Marshal, and Image can lay it out in ways Marshal
+// never does: repeated strings stored twice, strings out of entry order or
+// with unreferenced text between them, unaligned tables, text before the
+// menu table. Variants returns one image of each kind.
+package fixture
+
+import (
+	"encoding/binary"
+	"math"
+	"mhfjmp-editor/jmp"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// Filler is the byte written between the header and the menu table.
+const Filler = 0xAB
+
+// Image describes a file to build.
+type Image struct {
+	// MenuOffset is where the menu table starts. 0 puts it right after the
+	// header; anything between the header and the table is Filler.
+	MenuOffset uint32
+	Menu       []jmp.MenuEntry
+	Areas      []jmp.Area
+	// Trailer is written after the area data.
+	Trailer []byte
+
+	// Reversed writes the strings of the last entry first, description
+	// before title, instead of in entry order.
+	Reversed bool
+	// Unused is text no entry points to, written after the first string.
+	Unused []string
+	// AreaAlign is the alignment of the area table. 0 means 4, 1 writes it
+	// right after the text.
+	AreaAlign int
+	// AfterMenu is written right after the menu table, before the text or,
+	// with TextFirst, the area table.
+	AfterMenu []byte
+	// TextFirst writes the text right after the header and the menu table
+	// after it, 4-byte aligned, instead of at MenuOffset. The area table
+	// then follows the menu.
+	TextFirst bool
+}
+
+// Variants returns Default and images laid out in the ways Image allows, by
+// name. Every one of them holds the same menu and areas except "repeated
+// strings", whose entries share text.
+func Variants() map[string]Image {
+	repeated := Default()
+	repeated.Menu[2].Description = repeated.Menu[0].Description
+	repeated.Menu[3].Title = repeated.Menu[1].Title
+	repeated.Menu[3].Description = repeated.Menu[0].Description
+
+	reordered := Default()
+	reordered.Reversed = true
+
+	unused := Default()
+	unused.Unused = []string{"古いタイトル", "old description"}
+
+	// The menu where the old injector appended it: after the source file
+	// and 17 bytes of marker
+	unaligned := Default()
+	unaligned.MenuOffset = 0x100 + 17
+	unaligned.AreaAlign = 1
+
+	textFirst := Default()
+	textFirst.TextFirst = true
+
+	return map[string]Image{
+		"default":          Default(),
+		"repeated strings": repeated,
+		"reversed strings": reordered,
+		"unused strings":   unused,
+		"unaligned":        unaligned,
+		"text first":       textFirst,
+	}
+}
+
+// Default returns a small image with Japanese strings, an area without
+// entries, one without stage IDs and a trailer, similar to a client file.
+func Default() Image {
+	img := Image{MenuOffset: 0x100, Trailer: []byte("TRAILER")}
+	titles := []string{"メゼポルタ広場", "ギルド酒場", "マイハウス", "Rasta Bar"}
+	for i, title := range titles {
+		img.Menu = append(img.Menu, jmp.MenuEntry{
+			JumpID:      uint32(1000 + i),
+			Unk0C:       uint32(i),
+			AreaID:      uint16(i%3 + 1),
+			AreaID2:     uint16(i % 2),
+			Unk18:       uint16(i * 7),
+			PosX:        1.5 * float32(i),
+			PosY:        2,
+			PosZ:        -3.25,
+			Rotation:    uint32(i * 90),
+			PosX1:       0.125,
+			Rotation1:   uint32(i),
+			Title:       title,
+			Description: "説明 " + title,
+		})
+	}
+	img.Areas = []jmp.Area{
+		{Entries: []jmp.AreaEntry{{Index: 1, Flags: 2}, {Index: 3, Flags: 4}}, StageIDs: []uint16{100, 101}},
+		{Entries: []jmp.AreaEntry{{Index: 5, Flags: 0x8000}}, StageIDs: []uint16{200}},
+		{StageIDs: []uint16{300, 301, 302}},
+		{Entries: []jmp.AreaEntry{{Index: 7, Flags: 8}, {Index: 9, Flags: 10}}},
+	}
+	return img
+}
+
+// Build returns the image. It panics if a string cannot be encoded as
+// Shift-JIS, since that is a mistake in the test.
+func (img Image) Build() []byte {
+	type ref struct {
+		entry int
+		field int // 0 title, 1 description
+		text  string
+	}
+	var refs []ref
+	for i, entry := range img.Menu {
+		refs = append(refs, ref{i, 0, entry.Title}, ref{i, 1, entry.Description})
+	}
+	if img.Reversed {
+		for i, j := 0, len(refs)-1; i < j; i, j = i+1, j-1 {
+			refs[i], refs[j] = refs[j], refs[i]
+		}
+	}
+	// writeText appends the strings and returns their offsets, two per
+	// entry, title first
+	writeText := func(out []byte) ([]byte, []uint32) {
+		offsets := make([]uint32, len(img.Menu)*2)
+		for i, r := range refs {
+			offsets[r.entry*2+r.field] = uint32(len(out))
+			out = appendString(out, r.text)
+			if i == 0 {
+				for _, s := range img.Unused {
+					out = appendString(out, s)
+				}
+			}
+		}
+		return out, offsets
+	}
+
+	out := make([]byte, jmp.HeaderSize)
+	var text []uint32
+	menuOffset := int(img.MenuOffset)
+	if img.TextFirst {
+		out, text = writeText(out)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+		menuOffset = len(out)
+	} else if menuOffset == 0 {
+		menuOffset = jmp.HeaderSize
+	}
+	for len(out) < menuOffset {
+		out = append(out, Filler)
+	}
+
+	out = append(out, make([]byte, len(img.Menu)*jmp.MenuEntrySize)...)
+	for i, entry := range img.Menu {
+		b := out[menuOffset+i*jmp.MenuEntrySize:]
+		binary.LittleEndian.PutUint32(b[0:], entry.JumpID)
+		binary.LittleEndian.PutUint32(b[4:], entry.Unk0C)
+		binary.LittleEndian.PutUint16(b[8:], entry.AreaID)
+		binary.LittleEndian.PutUint16(b[10:], entry.AreaID2)
+		binary.LittleEndian.PutUint16(b[12:], entry.AreaID3)
+		binary.LittleEndian.PutUint16(b[14:], entry.Unk18)
+		binary.LittleEndian.PutUint32(b[16:], math.Float32bits(entry.PosX))
+		binary.LittleEndian.PutUint32(b[20:], math.Float32bits(entry.PosY))
+		binary.LittleEndian.PutUint32(b[24:], math.Float32bits(entry.PosZ))
+		binary.LittleEndian.PutUint32(b[28:], entry.Rotation)
+		binary.LittleEndian.PutUint32(b[32:], math.Float32bits(entry.PosX1))
+		binary.LittleEndian.PutUint32(b[36:], math.Float32bits(entry.PosY1))
+		binary.LittleEndian.PutUint32(b[40:], math.Float32bits(entry.PosZ1))
+		binary.LittleEndian.PutUint32(b[44:], entry.Rotation1)
+	}
+	out = append(out, img.AfterMenu...)
+	if !img.TextFirst {
+		out, text = writeText(out)
+	}
+	for i := range img.Menu {
+		b := out[menuOffset+i*jmp.MenuEntrySize:]
+		binary.LittleEndian.PutUint32(b[48:], text[i*2])
+		binary.LittleEndian.PutUint32(b[52:], text[i*2+1])
+	}
+	areaAlign := img.AreaAlign
+	if areaAlign == 0 {
+		areaAlign = 4
+	}
+	for len(out)%areaAlign != 0 {
+		out = append(out, 0)
+	}
+
+	tableOffset := len(out)
+	out = append(out, make([]byte, len(img.Areas)*jmp.AreaHeaderSize)...)
+	for i, area := range img.Areas {
+		header := out[tableOffset+i*jmp.AreaHeaderSize:]
+		binary.LittleEndian.PutUint32(header[0:], uint32(len(out)))
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(area.Entries)))
+		for _, entry := range area.Entries {
+			out = binary.LittleEndian.AppendUint16(out, entry.Index)
+			out = binary.LittleEndian.AppendUint16(out, entry.Flags)
+		}
+		header = out[tableOffset+i*jmp.AreaHeaderSize:]
+		binary.LittleEndian.PutUint32(header[8:], uint32(len(out)))
+		for _, id := range area.StageIDs {
+			out = binary.LittleEndian.AppendUint16(out, id)
+		}
+		out = binary.LittleEndian.AppendUint16(out, 0)
+	}
+	out = append(out, img.Trailer...)
+
+	binary.LittleEndian.PutUint32(out[0:], uint32(menuOffset))
+	binary.LittleEndian.PutUint32(out[4:], uint32(tableOffset))
+	binary.LittleEndian.PutUint32(out[8:], uint32(len(img.Areas)))
+	return out
+}
+
+func appendString(out []byte, s string) []byte {
+	b, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		panic("fixture: " + err.Error())
+	}
+	return append(append(out, b...), 0)
+}
@@ -0,0 +1,35 @@
+// Package number parses the integers written in the CSV files, the input
+// CSVs and the name mapping alike.
+package number
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ParseUint parses a decimal, 0x-prefixed hexadecimal or 0b-prefixed binary
+// integer that fits in bitSize bits. A leading 0 does not mean octal. The
+// error is strconv.ErrSyntax or strconv.ErrRange, without the
+// "strconv.ParseUint: parsing ..." prefix.
+func ParseUint(s string, bitSize int) (uint64, error) {
+	s = strings.TrimSpace(s)
+	base := 10
+	if len(s) > 2 && s[0] == '0' {
+		switch s[1] {
+		case 'x', 'X':
+			base, s = 16, s[2:]
+		case 'b', 'B':
+			base, s = 2, s[2:]
+		}
+	}
+	v, err := strconv.ParseUint(s, base, bitSize)
+	if err != nil {
+		var ne *strconv.NumError
+		if errors.As(err, &ne) {
+			return 0, ne.Err
+		}
+		return 0, err
+	}
+	return v, nil
+}
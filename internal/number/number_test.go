@@ -0,0 +1,37 @@
+package number
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestParseUint(t *testing.T) {
+	tests := []struct {
+		in      string
+		bitSize int
+		want    uint64
+		err     error
+	}{
+		{"500", 16, 500, nil},
+		{" 0x1F4 ", 16, 500, nil},
+		{"0X1f4", 16, 500, nil},
+		{"0b101", 16, 5, nil},
+		{"0B101", 16, 5, nil},
+		{"0755", 16, 755, nil},
+		{"0", 16, 0, nil},
+		{"0xFFFF", 16, 0xFFFF, nil},
+		{"0x10000", 16, 0, strconv.ErrRange},
+		{"65536", 16, 0, strconv.ErrRange},
+		{"0x10000", 32, 0x10000, nil},
+		{"0b12", 16, 0, strconv.ErrSyntax},
+		{"0x", 16, 0, strconv.ErrSyntax},
+		{"-1", 16, 0, strconv.ErrSyntax},
+		{"", 16, 0, strconv.ErrSyntax},
+	}
+	for _, tt := range tests {
+		got, err := ParseUint(tt.in, tt.bitSize)
+		if got != tt.want || err != tt.err {
+			t.Errorf("ParseUint(%q, %d) = %d, %v, want %d, %v", tt.in, tt.bitSize, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package jmp
+
+import "encoding/binary"
+
+// compactBlobs returns f.Blobs without the tables earlier ModeAppend runs
+// left in the image, and the number of bytes left out.
+//
+// A file written in ModeAppend is the source image with a new header,
+// followed by the append marker and the new tables. When the blob between
+// the header and the menu table ends with the marker, the bytes before the
+// marker are that source image. Its header is gone, so its menu and area
+// table are found by their structure: a menu table ending where the first
+// string it points to starts, an area table whose headers point at the area
+// data right after it, one list after the other. If the image parses with
+// them, the blob is replaced by the blobs of that image, which drops its
+// menu, text, area table and area data along with the marker. Earlier
+// cycles are dropped the same way. A blob in which no such image is found is
+// kept whole.
+func (f *File) compactBlobs() ([]Blob, int) {
+	menu := -1
+	for _, s := range f.sections {
+		if s.kind == sectionMenu {
+			menu = s.offset
+		}
+	}
+	for i, b := range f.Blobs {
+		end := b.Offset + len(b.Data)
+		if b.Offset != HeaderSize || end != menu || !isAppendMarker(b.Data) {
+			continue
+		}
+		prev := previousImage(f.raw[:end-appendMarkerSize])
+		if prev == nil {
+			break
+		}
+		kept, _ := prev.compactBlobs()
+		blobs := append(append(append([]Blob(nil), f.Blobs[:i]...), kept...), f.Blobs[i+1:]...)
+		dropped := len(b.Data)
+		for _, k := range kept {
+			dropped -= len(k.Data)
+		}
+		return blobs, dropped
+	}
+	return f.Blobs, 0
+}
+
+func isAppendMarker(data []byte) bool {
+	if len(data) < appendMarkerSize || data[len(data)-1] != 0xFF {
+		return false
+	}
+	return isZero(data[len(data)-appendMarkerSize : len(data)-1])
+}
+
+// previousImage parses the source image of an appended file, whose header
+// was overwritten, or returns nil if its tables cannot be found.
+func previousImage(data []byte) *File {
+	menu, ok := findMenuTable(data)
+	if !ok {
+		return nil
+	}
+	table, count, ok := findAreaTable(data)
+	if !ok || table < menu {
+		return nil
+	}
+	img := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(img[0x00:], uint32(menu))
+	binary.LittleEndian.PutUint32(img[0x04:], uint32(table))
+	binary.LittleEndian.PutUint32(img[0x08:], uint32(count))
+	prev, err := Parse(img)
+	if err != nil || len(prev.Menu) == 0 || len(prev.Areas) != count {
+		return nil
+	}
+	return prev
+}
+
+// findMenuTable returns the offset of the last menu table in data: a run of
+// entries whose titles and descriptions all lie after the run, the first of
+// them right after it. Every entry of a table starts such a run too, ending
+// at the same string, so the first of these is the start of the table.
+//
+// The run from an entry is the run from the next one, cut short at the first
+// string of the entry, so runs are followed from the end of data back and
+// each takes constant time.
+func findMenuTable(data []byte) (int, bool) {
+	// end[at] is where the run from at stops, text[at] the first string of
+	// the entries before that
+	end := make([]int, len(data)+1)
+	text := make([]int, len(data)+1)
+	for at := len(data); at >= HeaderSize; at-- {
+		end[at], text[at] = at, len(data)
+		if at+MenuEntrySize > len(data) {
+			continue
+		}
+		title := int(binary.LittleEndian.Uint32(data[at+48:]))
+		description := int(binary.LittleEndian.Uint32(data[at+52:]))
+		first, next := min(title, description), at+MenuEntrySize
+		if first < next || max(title, description) >= len(data) {
+			continue
+		}
+		// The run from next, unless it reaches the string first
+		reach := next + (first-next+MenuEntrySize-1)/MenuEntrySize*MenuEntrySize
+		if reach < end[next] {
+			end[at], text[at] = reach, first
+		} else {
+			end[at], text[at] = end[next], min(first, text[next])
+		}
+	}
+
+	found, foundText := -1, -1
+	for start := HeaderSize; start+MenuEntrySize <= len(data); start++ {
+		if end[start] == text[start] && text[start] != foundText {
+			found, foundText = start, text[start]
+		}
+	}
+	return found, found >= 0
+}
+
+// findAreaTable returns the offset of the last area table in data, whose
+// headers point at their entry and stage ID lists in order, starting right
+// after the table or one byte later, and the number of areas.
+func findAreaTable(data []byte) (offset, n int, ok bool) {
+	u32 := func(at int) int {
+		return int(binary.LittleEndian.Uint32(data[at:]))
+	}
+	// terminator[at] is the offset of the 0 ending a stage ID list at at,
+	// or -1 if the list runs past the end of data
+	terminator := make([]int, len(data)+2)
+	for at := len(data) + 1; at >= 0; at-- {
+		switch {
+		case at+2 > len(data):
+			terminator[at] = -1
+		case binary.LittleEndian.Uint16(data[at:]) == 0:
+			terminator[at] = at
+		default:
+			terminator[at] = terminator[at+2]
+		}
+	}
+	// chain[at] is the number of headers from at on whose lists follow one
+	// another
+	chain := make([]int, len(data)+1)
+	for at := len(data) - AreaHeaderSize; at >= HeaderSize; at-- {
+		stageIDs := u32(at + 8)
+		if stageIDs != u32(at)+u32(at+4)*AreaEntrySize || stageIDs >= len(data) || terminator[stageIDs] < 0 {
+			continue
+		}
+		chain[at] = 1
+		next := at + AreaHeaderSize
+		if next+AreaHeaderSize <= len(data) && u32(next) == terminator[stageIDs]+2 {
+			chain[at] += chain[next]
+		}
+	}
+
+	for start := HeaderSize; start+AreaHeaderSize <= len(data); start++ {
+		first := u32(start)
+		if first < start+AreaHeaderSize || first >= len(data) || (first-start)%AreaHeaderSize > 1 {
+			continue
+		}
+		if count := (first - start) / AreaHeaderSize; chain[start] >= count {
+			offset, n, ok = start, count, true
+		}
+	}
+	return offset, n, ok
+}
@@ -0,0 +1,96 @@
+package jmp
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+var headerFields = []string{"MenuOffset", "AreaOffset", "AreaCount"}
+
+var menuEntryFields = []string{"JumpID", "Unk0C", "AreaID", "AreaID2", "AreaID3", "Unk18",
+	"PosX", "PosY", "PosZ", "Rotation", "PosX1", "PosY1", "PosZ1", "Rotation1", "Title pointer", "Description pointer"}
+
+// menuEntryFieldOffsets are the offsets of menuEntryFields in an entry.
+var menuEntryFieldOffsets = []int{0, 4, 8, 10, 12, 14, 16, 20, 24, 28, 32, 36, 40, 44, 48, 52}
+
+var areaHeaderFields = []string{"pEntryData", "lenEntryData", "pStageIds"}
+
+// FieldAt names the part of the parsed image offset belongs to, such as
+// "menu[3].PosX", "menu[3].Title text" or "areas[1].StageIDs[2]". Bytes
+// outside the known sections are named after the blob holding them.
+func (f *File) FieldAt(offset int) string {
+	if offset < 0 || offset >= len(f.raw) {
+		return fmt.Sprintf("past the end of the file (size 0x%X)", len(f.raw))
+	}
+	u32 := func(at int) int {
+		return int(binary.LittleEndian.Uint32(f.raw[at:]))
+	}
+	in := func(start, size int) bool {
+		return offset >= start && offset < start+size
+	}
+
+	if offset < HeaderSize {
+		return "header." + headerFields[offset/4]
+	}
+
+	menu := int(f.Header.MenuOffset)
+	if in(menu, len(f.Menu)*MenuEntrySize) {
+		rel := (offset - menu) % MenuEntrySize
+		field := len(menuEntryFieldOffsets) - 1
+		for field > 0 && menuEntryFieldOffsets[field] > rel {
+			field--
+		}
+		return fmt.Sprintf("menu[%d].%s", (offset-menu)/MenuEntrySize, menuEntryFields[field])
+	}
+
+	table := int(f.Header.AreaOffset)
+	if in(table, int(f.Header.AreaCount)*AreaHeaderSize) {
+		rel := offset - table
+		return fmt.Sprintf("areas[%d].%s", rel/AreaHeaderSize, areaHeaderFields[rel%AreaHeaderSize/4])
+	}
+	for i := 0; i < int(f.Header.AreaCount); i++ {
+		header := table + i*AreaHeaderSize
+		if entries := u32(header); in(entries, u32(header+4)*AreaEntrySize) {
+			rel := offset - entries
+			field := "Index"
+			if rel%AreaEntrySize >= 2 {
+				field = "Flags"
+			}
+			return fmt.Sprintf("areas[%d].Entries[%d].%s", i, rel/AreaEntrySize, field)
+		}
+		if stages := u32(header + 8); stages > 0 && offset >= stages {
+			j := (offset - stages) / 2
+			end := stages
+			for end+2 <= len(f.raw) && binary.LittleEndian.Uint16(f.raw[end:]) != 0 {
+				end += 2
+			}
+			if offset < end {
+				return fmt.Sprintf("areas[%d].StageIDs[%d]", i, j)
+			}
+			if offset < end+2 {
+				return fmt.Sprintf("areas[%d].StageIDs terminator", i)
+			}
+		}
+	}
+
+	for i := range f.Menu {
+		entry := menu + i*MenuEntrySize
+		for k, name := range []string{"Title", "Description"} {
+			ptr := u32(entry + 48 + k*4)
+			end := ptr
+			for end < len(f.raw) && f.raw[end] != 0 {
+				end++
+			}
+			if offset >= ptr && offset <= end {
+				return fmt.Sprintf("menu[%d].%s text (byte %d)", i, name, offset-ptr)
+			}
+		}
+	}
+
+	for _, b := range f.Blobs {
+		if in(b.Offset, len(b.Data)) {
+			return fmt.Sprintf("unknown block at 0x%X (byte %d of %d)", b.Offset, offset-b.Offset, len(b.Data))
+		}
+	}
+	return "text pool free space"
+}
@@ -0,0 +1,67 @@
+package jmp_test
+
+import (
+	"mhfjmp-editor/diff"
+	"mhfjmp-editor/internal/fixture"
+	"mhfjmp-editor/jmp"
+	"testing"
+)
+
+func addSeeds(f *testing.F) {
+	for _, img := range fixture.Variants() {
+		f.Add(img.Build())
+	}
+	f.Add(fixture.Image{Areas: fixture.Default().Areas}.Build())
+	f.Add(fixture.Image{Menu: fixture.Default().Menu[:1]}.Build())
+	f.Add([]byte{})
+	f.Add(make([]byte, jmp.HeaderSize))
+	file, err := jmp.Parse(fixture.Default().Build())
+	if err != nil {
+		f.Fatal(err)
+	}
+	appended, _, err := file.Marshal(jmp.MarshalOptions{Mode: jmp.ModeAppend})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(appended)
+}
+
+// FuzzParse checks that Parse never panics and that whatever it accepts can
+// be written back and parsed again to the same areas. The menu table has no
+// entry count, so in an arbitrary image the bytes after it may be read as
+// more entries once it has moved; only the entries it had are compared.
+func FuzzParse(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		file, err := jmp.Parse(data)
+		if err != nil {
+			return
+		}
+		for i := 0; i < len(data); i += 1 + i/64 {
+			file.FieldAt(i)
+		}
+		for _, opts := range []jmp.MarshalOptions{{Mode: jmp.ModeRebuild}, {Mode: jmp.ModeAppend}, {Compact: true}} {
+			mode := opts.Mode.String()
+			if opts.Compact {
+				mode += " -compact"
+			}
+			out, _, err := file.Marshal(opts)
+			if err != nil {
+				// Text that was not valid Shift-JIS cannot be written back
+				continue
+			}
+			again, err := jmp.Parse(out)
+			if err != nil {
+				t.Fatalf("%s output does not parse: %v", mode, err)
+			}
+			n := len(file.Menu)
+			if len(again.Menu) < n {
+				t.Fatalf("%s output has %d menu entries, want %d", mode, len(again.Menu), n)
+			}
+			changes := append(diff.Menu(file.Menu, again.Menu[:n]), diff.Areas(file.Areas, again.Areas)...)
+			if len(changes) > 0 {
+				t.Fatalf("%s output differs from the input: %v", mode, changes)
+			}
+		}
+	})
+}
@@ -0,0 +1,72 @@
+// Package jmp models the mhfjmp.bin file used by the Monster Hunter Frontier Z
+// jump menu and converts it to and from its binary form.
+package jmp
+
+import "errors"
+
+// ErrMissingInput is an input file that does not exist. The extractor,
+// injector and verify packages all return it, so a caller needs one check.
+var ErrMissingInput = errors.New("input file not found")
+
+const (
+	HeaderSize     = 0x0C
+	MenuEntrySize  = 56
+	AreaHeaderSize = 12
+	AreaEntrySize  = 4
+)
+
+// Header is the fixed block at the start of mhfjmp.bin.
+type Header struct {
+	MenuOffset uint32 // 0x00
+	AreaOffset uint32 // 0x04
+	AreaCount  uint32 // 0x08
+}
+
+type MenuEntry struct {
+	JumpID      uint32  `json:"jumpId"`
+	Unk0C       uint32  `json:"unk0C"`
+	AreaID      uint16  `json:"areaId"`
+	AreaID2     uint16  `json:"areaId2"`
+	AreaID3     uint16  `json:"areaId3"`
+	Unk18       uint16  `json:"unk18"`
+	PosX        float32 `json:"posX"`
+	PosY        float32 `json:"posY"`
+	PosZ        float32 `json:"posZ"`
+	Rotation    uint32  `json:"rotation"`
+	PosX1       float32 `json:"posX1"`
+	PosY1       float32 `json:"posY1"`
+	PosZ1       float32 `json:"posZ1"`
+	Rotation1   uint32  `json:"rotation1"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+}
+
+type AreaEntry struct {
+	Index uint16 `json:"index"`
+	Flags uint16 `json:"flags"`
+}
+
+// Area is one record of the area table. On disk it is a 12 byte header
+// (pEntryData, lenEntryData, pStageIds) pointing at the entry list and at a
+// 0-terminated list of stage IDs.
+type Area struct {
+	Entries  []AreaEntry `json:"entries"`
+	StageIDs []uint16    `json:"stageIds"`
+}
+
+// File is a parsed mhfjmp.bin. Its JSON form only holds the menu entries and
+// the areas; the header is derived from them when the file is written.
+// Blobs are the bytes of the parsed image outside the known sections.
+type File struct {
+	Header Header      `json:"-"`
+	Menu   []MenuEntry `json:"menu"`
+	Areas  []Area      `json:"areas"`
+	Blobs  []Blob      `json:"-"`
+
+	// raw is the image the file was parsed from, copied as is by ModeAppend.
+	// sections is where scan found each known section in it, strings where
+	// it found the title and description of each parsed menu entry.
+	raw      []byte
+	sections []section
+	strings  []sourceString
+}
@@ -0,0 +1,348 @@
+package jmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// appendMarkerSize is the 16 zero bytes and 0xFF written in front of an
+// appended menu table.
+const appendMarkerSize = 17
+
+// ErrStringPoolOverflow is returned by Marshal when the encoded titles and
+// descriptions do not fit in MarshalOptions.StringLimit.
+var ErrStringPoolOverflow = errors.New("string pool exceeds size limit")
+
+// ErrZeroStageID is returned by Marshal for a stage ID list holding a 0,
+// which is the value that ends the list on disk.
+var ErrZeroStageID = errors.New("stage ID 0 is the list terminator")
+
+// Mode selects how Marshal lays out the output file.
+type Mode int
+
+const (
+	// ModeRebuild writes the sections and blobs of the source image in their
+	// original order, regenerating the menu table, the text, the area table
+	// and the area data. Sections keep the alignment they had and unchanged
+	// titles and descriptions keep their place in the text, so a file that
+	// was not edited comes out unchanged; sections that grow move everything
+	// after them.
+	ModeRebuild Mode = iota
+	// ModeAppend copies the source image as is and appends the new sections
+	// after it, leaving the old tables in place as dead bytes.
+	ModeAppend
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeRebuild:
+		return "rebuild"
+	case ModeAppend:
+		return "append"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "rebuild":
+		return ModeRebuild, nil
+	case "append":
+		return ModeAppend, nil
+	}
+	return 0, fmt.Errorf("unknown mode '%s', expected 'rebuild' or 'append'", s)
+}
+
+type MarshalOptions struct {
+	Mode Mode
+	// StringAlign is the alignment of the section following the string
+	// pool. 0 means 4.
+	StringAlign int
+	// StringPadding is the number of zero bytes reserved after the last
+	// string, before alignment.
+	StringPadding int
+	// StringLimit is the maximum size of the string pool including padding.
+	// 0 means no limit.
+	StringLimit int
+	// ShareSuffixes lets a new or changed string that ends another one point
+	// into it. New and changed strings identical to another string are
+	// always stored once; the strings of the source image are left as they
+	// are, repeated or not.
+	ShareSuffixes bool
+	// Compact leaves out the tables earlier ModeAppend runs left in the
+	// source image, so that a file appended to several times shrinks back.
+	// The blobs those runs copied along are kept, and so is every blob if
+	// the compacted file would not read back as f. ModeRebuild only.
+	Compact bool
+}
+
+// Layout describes where Marshal placed each section. StringSize is the size
+// of the encoded strings, StringReserved the space given to the pool once
+// padding and alignment are applied. In ModeRebuild the pool keeps the space
+// it had in the source image if the new text fits in it, and StringsKept
+// titles and descriptions were left where the source image had them;
+// Strings only counts the others.
+type Layout struct {
+	Mode           Mode
+	MenuOffset     int
+	StringOffset   int
+	StringSize     int
+	StringReserved int
+	AreaOffset     int
+	AreaDataOffset int
+	Blobs          int // blobs written back, ModeRebuild only
+	Dropped        int // bytes of superseded tables left out, Compact only
+	Size           int
+	StringsKept    int
+	Strings        PoolStats
+}
+
+// MarshalBinary serializes f in ModeRebuild.
+func (f *File) MarshalBinary() ([]byte, error) {
+	output, _, err := f.Marshal(MarshalOptions{})
+	return output, err
+}
+
+// Marshal serializes f. In both modes the header at 0x00-0x0B is rewritten
+// to point at the new sections.
+func (f *File) Marshal(opts MarshalOptions) ([]byte, Layout, error) {
+	layout := Layout{Mode: opts.Mode}
+	stringAlign := opts.StringAlign
+	if stringAlign == 0 {
+		stringAlign = 4
+	}
+	if stringAlign < 0 || opts.StringPadding < 0 || opts.StringLimit < 0 {
+		return nil, layout, fmt.Errorf("invalid string pool options: align %d, padding %d, limit %d",
+			opts.StringAlign, opts.StringPadding, opts.StringLimit)
+	}
+	if opts.Compact && opts.Mode != ModeRebuild {
+		return nil, layout, fmt.Errorf("compaction needs mode %v, not %v", ModeRebuild, opts.Mode)
+	}
+
+	// The tables of a previous image are found by their structure, so the
+	// compacted file is only used if it reads back as f
+	if opts.Compact {
+		if blobs, dropped := f.compactBlobs(); dropped > 0 {
+			output, layout, err := f.marshal(opts, stringAlign, blobs)
+			if err != nil {
+				return nil, layout, err
+			}
+			if f.readsBack(output) {
+				layout.Dropped = dropped
+				return output, layout, nil
+			}
+		}
+	}
+	return f.marshal(opts, stringAlign, f.Blobs)
+}
+
+// marshal serializes f, writing blobs in place of f.Blobs in ModeRebuild.
+func (f *File) marshal(opts MarshalOptions, stringAlign int, blobs []Blob) ([]byte, Layout, error) {
+	layout := Layout{Mode: opts.Mode}
+	var order []section
+	switch opts.Mode {
+	case ModeRebuild:
+		order = f.rebuildOrder(blobs)
+		layout.Blobs = len(blobs)
+	case ModeAppend:
+		base := f.raw
+		if len(base) < HeaderSize {
+			base = append(base[:len(base):len(base)], make([]byte, HeaderSize-len(base))...)
+		}
+		marker := make([]byte, appendMarkerSize)
+		marker[appendMarkerSize-1] = 0xFF
+		order = []section{{kind: sectionBlob, data: base}, {kind: sectionBlob, data: marker},
+			{kind: sectionMenu, added: true}, {kind: sectionText, added: true},
+			{kind: sectionAreaTable, added: true}, {kind: sectionAreaData, added: true}}
+	default:
+		return nil, layout, fmt.Errorf("unknown mode %v", opts.Mode)
+	}
+
+	// Lay out all text first, it is placed once its offset is known
+	textSection := section{kind: sectionText, added: true}
+	for _, sec := range order {
+		if sec.kind == sectionText {
+			textSection = sec
+		}
+	}
+	text, err := f.layoutText(textSection, opts.ShareSuffixes)
+	if err != nil {
+		return nil, layout, err
+	}
+	layout.StringSize = len(text.buf)
+	layout.StringsKept = text.kept
+	layout.Strings = text.stats
+
+	for i, area := range f.Areas {
+		for j, id := range area.StageIDs {
+			if id == 0 {
+				return nil, layout, fmt.Errorf("area %d stage ID %d: %w", i+1, j+1, ErrZeroStageID)
+			}
+		}
+	}
+
+	// Place every section and blob
+	offsets := make([]int, len(order))
+	pos := 0
+	for i, s := range order {
+		switch s.kind {
+		case sectionMenu:
+			if opts.Mode == ModeRebuild {
+				pos = s.alignFrom(pos, 4)
+			}
+			layout.MenuOffset = pos
+		case sectionText:
+			layout.StringOffset = pos
+		case sectionAreaTable:
+			pos = s.alignFrom(pos, 4)
+			layout.AreaOffset = pos
+		case sectionAreaData:
+			pos = s.alignFrom(pos, 2)
+			layout.AreaDataOffset = pos
+		}
+		offsets[i] = pos
+
+		switch s.kind {
+		case sectionBlob:
+			pos += len(s.data)
+		case sectionHeader:
+			pos += HeaderSize
+		case sectionMenu:
+			pos += len(f.Menu) * MenuEntrySize
+		case sectionText:
+			reserved := align(pos+len(text.buf)+opts.StringPadding, stringAlign) - pos
+			if opts.StringLimit > 0 && reserved > opts.StringLimit {
+				return nil, layout, fmt.Errorf("%w: %d bytes of text (%d with padding) > limit of %d bytes",
+					ErrStringPoolOverflow, layout.StringSize, reserved, opts.StringLimit)
+			}
+			if len(text.buf)+opts.StringPadding <= s.size {
+				reserved = s.size
+			}
+			layout.StringReserved = reserved
+			pos += reserved
+		case sectionAreaTable:
+			pos += len(f.Areas) * AreaHeaderSize
+		case sectionAreaData:
+			pos += areaDataSize(f.Areas)
+		}
+	}
+	layout.Size = pos
+
+	output := make([]byte, layout.Size)
+	for i, s := range order {
+		switch s.kind {
+		case sectionBlob:
+			copy(output[offsets[i]:], s.data)
+		case sectionMenu:
+			for j, entry := range f.Menu {
+				putMenuEntry(output[offsets[i]+j*MenuEntrySize:], entry,
+					uint32(layout.StringOffset+text.offsets[j*2]),
+					uint32(layout.StringOffset+text.offsets[j*2+1]))
+			}
+		case sectionText:
+			copy(output[offsets[i]:], text.buf)
+		case sectionAreaTable:
+			putAreas(output, offsets[i], layout.AreaDataOffset, f.Areas)
+		}
+	}
+
+	binary.LittleEndian.PutUint32(output[0x00:], uint32(layout.MenuOffset))
+	binary.LittleEndian.PutUint32(output[0x04:], uint32(layout.AreaOffset))
+	binary.LittleEndian.PutUint32(output[0x08:], uint32(len(f.Areas)))
+	return output, layout, nil
+}
+
+// readsBack reports whether output parses to the menu entries and areas of
+// f.
+func (f *File) readsBack(output []byte) bool {
+	g, err := Parse(output)
+	if err != nil || len(g.Menu) != len(f.Menu) || len(g.Areas) != len(f.Areas) {
+		return false
+	}
+	for i := range f.Menu {
+		if g.Menu[i] != f.Menu[i] {
+			return false
+		}
+	}
+	for i, area := range f.Areas {
+		if !slices.Equal(g.Areas[i].Entries, area.Entries) || !slices.Equal(g.Areas[i].StageIDs, area.StageIDs) {
+			return false
+		}
+	}
+	return true
+}
+
+func align(n, to int) int {
+	return (n + to - 1) / to * to
+}
+
+// alignFrom returns the first offset from pos at which s has the alignment
+// it had in the parsed image, so that a file written with an unaligned table
+// keeps it where it was. A section the image did not have is aligned to to.
+func (s section) alignFrom(pos, to int) int {
+	if s.added {
+		return align(pos, to)
+	}
+	return pos + ((s.offset-pos)%to+to)%to
+}
+
+func putMenuEntry(b []byte, entry MenuEntry, title, description uint32) {
+	binary.LittleEndian.PutUint32(b[0:], entry.JumpID)
+	binary.LittleEndian.PutUint32(b[4:], entry.Unk0C)
+	binary.LittleEndian.PutUint16(b[8:], entry.AreaID)
+	binary.LittleEndian.PutUint16(b[10:], entry.AreaID2)
+	binary.LittleEndian.PutUint16(b[12:], entry.AreaID3)
+	binary.LittleEndian.PutUint16(b[14:], entry.Unk18)
+	putFloat32(b[16:], entry.PosX)
+	putFloat32(b[20:], entry.PosY)
+	putFloat32(b[24:], entry.PosZ)
+	binary.LittleEndian.PutUint32(b[28:], entry.Rotation)
+	putFloat32(b[32:], entry.PosX1)
+	putFloat32(b[36:], entry.PosY1)
+	putFloat32(b[40:], entry.PosZ1)
+	binary.LittleEndian.PutUint32(b[44:], entry.Rotation1)
+	binary.LittleEndian.PutUint32(b[48:], title)
+	binary.LittleEndian.PutUint32(b[52:], description)
+}
+
+// areaDataSize is the size of the entry list and the 0-terminated stage ID
+// list of every area.
+func areaDataSize(areas []Area) int {
+	size := 0
+	for _, area := range areas {
+		size += len(area.Entries)*AreaEntrySize + len(area.StageIDs)*2 + 2
+	}
+	return size
+}
+
+// putAreas writes the area headers at offset and the data of each area in
+// order at dataOffset.
+func putAreas(output []byte, offset, dataOffset int, areas []Area) {
+	for i, area := range areas {
+		header := output[offset+i*AreaHeaderSize:]
+		stageIdsOffset := dataOffset + len(area.Entries)*AreaEntrySize
+		binary.LittleEndian.PutUint32(header[0:], uint32(dataOffset))
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(area.Entries)))
+		binary.LittleEndian.PutUint32(header[8:], uint32(stageIdsOffset))
+
+		for j, entry := range area.Entries {
+			binary.LittleEndian.PutUint16(output[dataOffset+j*AreaEntrySize:], entry.Index)
+			binary.LittleEndian.PutUint16(output[dataOffset+j*AreaEntrySize+2:], entry.Flags)
+		}
+		for j, stageId := range area.StageIDs {
+			binary.LittleEndian.PutUint16(output[stageIdsOffset+j*2:], stageId)
+		}
+		// Stage IDs are terminated by a uint16(0)
+		terminatorOffset := stageIdsOffset + len(area.StageIDs)*2
+		binary.LittleEndian.PutUint16(output[terminatorOffset:], 0)
+
+		dataOffset = terminatorOffset + 2
+	}
+}
+
+func putFloat32(b []byte, f float32) {
+	binary.LittleEndian.PutUint32(b, math.Float32bits(f))
+}
@@ -0,0 +1,391 @@
+package jmp_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"mhfjmp-editor/internal/fixture"
+	"mhfjmp-editor/jmp"
+	"reflect"
+	"testing"
+)
+
+func parse(t *testing.T, data []byte) *jmp.File {
+	t.Helper()
+	f, err := jmp.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// textPointers returns the title and description pointers of every entry.
+func textPointers(data []byte, f *jmp.File) []uint32 {
+	var ptrs []uint32
+	for i := range f.Menu {
+		at := int(f.Header.MenuOffset) + i*jmp.MenuEntrySize
+		ptrs = append(ptrs, binary.LittleEndian.Uint32(data[at+48:]), binary.LittleEndian.Uint32(data[at+52:]))
+	}
+	return ptrs
+}
+
+func TestMarshalUnchanged(t *testing.T) {
+	for name, img := range fixture.Variants() {
+		t.Run(name, func(t *testing.T) {
+			data := img.Build()
+			got, err := parse(t, data).MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("MarshalBinary() of an unedited file changed it (%d bytes, want %d)", len(got), len(data))
+			}
+		})
+	}
+}
+
+// A file written in ModeAppend has its menu wherever the source ended plus
+// the marker, which is rarely 4-byte aligned. Rebuilding it must not move it.
+func TestMarshalAppendThenRebuild(t *testing.T) {
+	for n := 0; n < 4; n++ {
+		t.Run(fmt.Sprintf("trailer %d", n), func(t *testing.T) {
+			img := fixture.Default()
+			img.Trailer = bytes.Repeat([]byte{0xEE}, n)
+			appended, _, err := parse(t, img.Build()).Marshal(jmp.MarshalOptions{Mode: jmp.ModeAppend})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parse(t, appended).MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, appended) {
+				t.Errorf("rebuild of an appended file changed it (%d bytes, want %d)", len(got), len(appended))
+			}
+		})
+	}
+}
+
+// Edited text goes into the space of the text it replaces, the file does not
+// grow across edit cycles and the other strings stay where they are.
+func TestMarshalEditCycles(t *testing.T) {
+	data := fixture.Default().Build()
+	size := len(data)
+	for cycle := 0; cycle < 5; cycle++ {
+		f := parse(t, data)
+		before := textPointers(data, f)
+		f.Menu[1].Title = fmt.Sprintf("Edit %d", cycle)
+		want := f.Menu
+
+		out, err := f.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != size {
+			t.Fatalf("cycle %d: size %d, want %d", cycle, len(out), size)
+		}
+		g := parse(t, out)
+		if !reflect.DeepEqual(g.Menu, want) {
+			t.Fatalf("cycle %d: menu =\n%+v\nwant\n%+v", cycle, g.Menu, want)
+		}
+		after := textPointers(out, g)
+		for i := range before {
+			if i != 2 && after[i] != before[i] {
+				t.Errorf("cycle %d: pointer %d moved from 0x%X to 0x%X", cycle, i, before[i], after[i])
+			}
+		}
+		data = out
+	}
+}
+
+// With every entry removed, text left after the empty menu table would be
+// read as an entry.
+func TestMarshalEmptyMenu(t *testing.T) {
+	for name, img := range fixture.Variants() {
+		t.Run(name, func(t *testing.T) {
+			f := parse(t, img.Build())
+			f.Menu = nil
+			out, err := f.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := parse(t, out)
+			if len(g.Menu) != 0 || !reflect.DeepEqual(g.Areas, f.Areas) {
+				t.Errorf("file holds\n%+v\n%+v\nwant no entries and\n%+v", g.Menu, g.Areas, f.Areas)
+			}
+		})
+	}
+}
+
+// Text before the menu table does not end it, so it keeps its offsets when
+// the first string moves out.
+func TestMarshalTextFirst(t *testing.T) {
+	img := fixture.Default()
+	img.TextFirst = true
+	data := img.Build()
+	f := parse(t, data)
+	before := textPointers(data, f)
+	if before[0] != jmp.HeaderSize {
+		t.Fatalf("first title at 0x%X, want 0x%X", before[0], jmp.HeaderSize)
+	}
+	f.Menu[0].Title = "メゼポルタ広場の大きな入口"
+
+	out, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := parse(t, out)
+	if !reflect.DeepEqual(g.Menu, f.Menu) || !reflect.DeepEqual(g.Areas, f.Areas) {
+		t.Fatalf("file holds\n%+v\n%+v\nwant\n%+v\n%+v", g.Menu, g.Areas, f.Menu, f.Areas)
+	}
+	after := textPointers(out, g)
+	for i := 1; i < len(before); i++ {
+		if after[i] != before[i] {
+			t.Errorf("pointer %d moved from 0x%X to 0x%X", i, before[i], after[i])
+		}
+	}
+}
+
+// Only new and changed text is interned; strings the file stores twice stay
+// twice.
+func TestMarshalInterning(t *testing.T) {
+	img := fixture.Default()
+	img.Menu[3].Description = img.Menu[2].Description
+	f := parse(t, img.Build())
+	f.Menu[0].Title = f.Menu[1].Title
+	f.Menu[0].Description = "New"
+	f.Menu[1].Description = "New"
+
+	out, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := parse(t, out)
+	if !reflect.DeepEqual(g.Menu, f.Menu) {
+		t.Fatalf("menu =\n%+v\nwant\n%+v", g.Menu, f.Menu)
+	}
+	ptrs := textPointers(out, g)
+	for _, tt := range []struct {
+		name string
+		a, b int
+		same bool
+	}{
+		{"title changed to an existing one", 0, 2, true},
+		{"same new description", 1, 3, true},
+		{"description stored twice", 5, 7, false},
+	} {
+		if (ptrs[tt.a] == ptrs[tt.b]) != tt.same {
+			t.Errorf("%s: pointers 0x%X and 0x%X, want same = %v", tt.name, ptrs[tt.a], ptrs[tt.b], tt.same)
+		}
+	}
+}
+
+// A new string that ends another new one points into it, unless it would
+// start on the second byte of a double-byte character: "A" is 0x41, the
+// last byte of "ア" (0x83 0x41).
+func TestMarshalShareSuffixes(t *testing.T) {
+	edit := func(f *jmp.File) {
+		f.Menu[0].Title, f.Menu[0].Description = "Mezeporta Plaza", "Plaza"
+		f.Menu[1].Title, f.Menu[1].Description = "ギルドア", "A"
+		f.Menu[2].Title, f.Menu[2].Description = "新マイハウス", "マイハウス"
+	}
+	tests := []struct {
+		share bool
+		stats jmp.PoolStats
+	}{
+		{false, jmp.PoolStats{Strings: 6, Unique: 6, Size: 57}},
+		{true, jmp.PoolStats{Strings: 6, Unique: 6, Shared: 2, Size: 40, Saved: 17}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("share %v", tt.share), func(t *testing.T) {
+			f := parse(t, fixture.Default().Build())
+			edit(f)
+			out, layout, err := f.Marshal(jmp.MarshalOptions{ShareSuffixes: tt.share})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if layout.Strings != tt.stats {
+				t.Errorf("Strings = %+v, want %+v", layout.Strings, tt.stats)
+			}
+			g := parse(t, out)
+			if !reflect.DeepEqual(g.Menu, f.Menu) {
+				t.Fatalf("menu =\n%+v\nwant\n%+v", g.Menu, f.Menu)
+			}
+			ptrs := textPointers(out, g)
+			for _, s := range []struct {
+				name   string
+				k      int
+				into   uint32 // bytes into the title, if shared
+				shared bool
+			}{
+				{"Plaza", 1, 10, tt.share},
+				{"マイハウス", 5, 2, tt.share},
+				{"A", 3, 7, false},
+			} {
+				if got := ptrs[s.k] == ptrs[s.k-1]+s.into; got != s.shared {
+					t.Errorf("%s at 0x%X, title at 0x%X, shared = %v, want %v", s.name, ptrs[s.k], ptrs[s.k-1], got, s.shared)
+				}
+			}
+		})
+	}
+}
+
+// Compact drops the tables of every append cycle and keeps the blobs of the
+// source image; it leaves a file that was never appended to as it is.
+func TestMarshalCompact(t *testing.T) {
+	for name, img := range fixture.Variants() {
+		t.Run(name, func(t *testing.T) {
+			data := img.Build()
+			out, layout, err := parse(t, data).Marshal(jmp.MarshalOptions{Compact: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, data) || layout.Dropped != 0 {
+				t.Errorf("compacting a file never appended to changed it (%d bytes, want %d, %d dropped)", len(out), len(data), layout.Dropped)
+			}
+
+			src := parse(t, data)
+			for cycle := 0; cycle < 3; cycle++ {
+				f := parse(t, data)
+				f.Menu[1].Title = fmt.Sprintf("Edit %d", cycle)
+				f.Areas[0].StageIDs = append(f.Areas[0].StageIDs, uint16(500+cycle))
+				if data, _, err = f.Marshal(jmp.MarshalOptions{Mode: jmp.ModeAppend}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			appended := parse(t, data)
+			out, layout, err = appended.Marshal(jmp.MarshalOptions{Compact: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := parse(t, out)
+			if !reflect.DeepEqual(g.Menu, appended.Menu) || !reflect.DeepEqual(g.Areas, appended.Areas) {
+				t.Errorf("compacted file holds\n%+v\n%+v\nwant\n%+v\n%+v", g.Menu, g.Areas, appended.Menu, appended.Areas)
+			}
+			if img.TextFirst {
+				// The menu table of the source image does not end at a
+				// string, so the tables of the first cycle are kept with it
+				if layout.Dropped == 0 || len(out) > 2*len(img.Build())+8 {
+					t.Errorf("compacted to %d bytes from %d, %d dropped, source was %d", len(out), len(data), layout.Dropped, len(img.Build()))
+				}
+				return
+			}
+			if layout.Dropped == 0 || len(out) > len(img.Build())+8 {
+				t.Errorf("compacted to %d bytes from %d, %d dropped, source was %d", len(out), len(data), layout.Dropped, len(img.Build()))
+			}
+			var got, want []byte
+			for _, b := range g.Blobs {
+				got = append(got, b.Data...)
+			}
+			for _, b := range src.Blobs {
+				want = append(want, b.Data...)
+			}
+			// The menu keeps its alignment, which may leave zero bytes in front
+			if !bytes.Equal(bytes.TrimRight(got, "\x00"), want) {
+				t.Errorf("compacted file keeps blobs %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// The tables of an earlier cycle are searched for in time linear in the size
+// of the source image. Here the source image holds a menu table of one entry
+// followed by area headers that all point at the same long stage ID list, so
+// that every one of them starts a table that only fails at its second area.
+func TestMarshalCompactLargeImage(t *testing.T) {
+	const size = 1 << 20
+	img := fixture.Default()
+	img.MenuOffset = size
+	data := img.Build()
+	menu := jmp.HeaderSize
+	areas := menu + jmp.MenuEntrySize + 4
+	binary.LittleEndian.PutUint32(data[menu+48:], uint32(areas-4))
+	binary.LittleEndian.PutUint32(data[menu+52:], uint32(areas-4))
+	copy(data[areas-4:], "A\x00\x00\x00")
+	stageIDs := areas + (size/2)/jmp.AreaHeaderSize*jmp.AreaHeaderSize
+	for at := areas; at < stageIDs; at += jmp.AreaHeaderSize {
+		binary.LittleEndian.PutUint32(data[at:], uint32(stageIDs))
+		binary.LittleEndian.PutUint32(data[at+4:], 0)
+		binary.LittleEndian.PutUint32(data[at+8:], uint32(stageIDs))
+	}
+	// Nor is the last header a table of one area
+	binary.LittleEndian.PutUint32(data[stageIDs-8:], 1)
+	copy(data[size-17:], append(make([]byte, 16), 0xFF))
+
+	out, layout, err := parse(t, data).Marshal(jmp.MarshalOptions{Compact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) || layout.Dropped != 0 {
+		t.Errorf("compacting a file with no earlier tables changed it (%d bytes dropped)", layout.Dropped)
+	}
+}
+
+// The text pool of the default fixture is 114 bytes at 0x3A4 when appended.
+// Padding is reserved after it, then the end is aligned; the area table
+// that follows is always 4-byte aligned.
+func TestMarshalStringPool(t *testing.T) {
+	const textOffset, textSize = 0x3A4, 114
+	tests := []struct {
+		align, padding int
+		reserved       int
+		areaOffset     int
+	}{
+		{0, 0, 116, 0x418},
+		{1, 0, 114, 0x418},
+		{16, 0, 124, 0x420},
+		{0, 5, 120, 0x41C},
+		{16, 10, 124, 0x420},
+		{16, 11, 140, 0x430},
+	}
+	data := fixture.Default().Build()
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("align %d padding %d", tt.align, tt.padding), func(t *testing.T) {
+			opts := jmp.MarshalOptions{Mode: jmp.ModeAppend, StringAlign: tt.align, StringPadding: tt.padding}
+			out, layout, err := parse(t, data).Marshal(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if layout.StringOffset != textOffset || layout.StringSize != textSize ||
+				layout.StringReserved != tt.reserved || layout.AreaOffset != tt.areaOffset {
+				t.Errorf("text at 0x%X, %d bytes, %d reserved, areas at 0x%X; want 0x%X, %d, %d, 0x%X",
+					layout.StringOffset, layout.StringSize, layout.StringReserved, layout.AreaOffset,
+					textOffset, textSize, tt.reserved, tt.areaOffset)
+			}
+			g := parse(t, out)
+			if ptrs := textPointers(out, g); ptrs[0] != textOffset || int(ptrs[len(ptrs)-1]) >= textOffset+textSize {
+				t.Errorf("text pointers %X, want 0x%X to 0x%X", ptrs, textOffset, textOffset+textSize)
+			}
+			if pad := out[textOffset+textSize : tt.areaOffset]; len(bytes.Trim(pad, "\x00")) != 0 {
+				t.Errorf("padding is %X, want zero bytes", pad)
+			}
+
+			// The limit counts the padding and alignment
+			opts.StringLimit = tt.reserved
+			if _, layout, err := parse(t, data).Marshal(opts); err != nil || layout.StringReserved != tt.reserved {
+				t.Errorf("Marshal() at the limit of %d: %d reserved, error %v", tt.reserved, layout.StringReserved, err)
+			}
+			opts.StringLimit = tt.reserved - 1
+			out, _, err = parse(t, data).Marshal(opts)
+			if !errors.Is(err, jmp.ErrStringPoolOverflow) || out != nil {
+				t.Errorf("Marshal() over the limit of %d: %d bytes, error %v, want ErrStringPoolOverflow", opts.StringLimit, len(out), err)
+			}
+		})
+	}
+}
+
+// Text growing past the space it had in the source image is checked against
+// the limit too.
+func TestMarshalStringLimitRebuild(t *testing.T) {
+	f := parse(t, fixture.Default().Build())
+	_, layout, err := f.Marshal(jmp.MarshalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Menu[3].Description += " and a longer description"
+	_, _, err = f.Marshal(jmp.MarshalOptions{StringLimit: layout.StringReserved})
+	if !errors.Is(err, jmp.ErrStringPoolOverflow) {
+		t.Errorf("Marshal() error = %v, want ErrStringPoolOverflow", err)
+	}
+}
@@ -0,0 +1,313 @@
+package jmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Parse decodes a complete mhfjmp.bin image.
+func Parse(data []byte) (*File, error) {
+	br := NewBytesReader(data)
+	f := &File{raw: data}
+
+	var err error
+	f.Header, err = ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	f.Menu, err = ReadMenu(br)
+	if err != nil {
+		return nil, err
+	}
+	f.Areas, err = ReadAreas(br)
+	if err != nil {
+		return nil, err
+	}
+	f.scan()
+	return f, nil
+}
+
+func ReadHeader(br *BinaryReader) (Header, error) {
+	var h Header
+	if _, err := br.Seek(0, io.SeekStart); err != nil {
+		return h, fmt.Errorf("failed to seek to header: %w", err)
+	}
+	var err error
+	h.MenuOffset, err = br.ReadUInt32()
+	if err != nil {
+		return h, fmt.Errorf("failed to read menu pointer at 0x00: %w", err)
+	}
+	h.AreaOffset, err = br.ReadUInt32()
+	if err != nil {
+		return h, fmt.Errorf("failed to read area pointer at 0x04: %w", err)
+	}
+	h.AreaCount, err = br.ReadUInt32()
+	if err != nil {
+		return h, fmt.Errorf("failed to read area count at 0x08: %w", err)
+	}
+	return h, nil
+}
+
+// ReadMenu reads the menu entry table the pointer at 0x00 refers to.
+//
+// The file does not store an entry count, so the table is read until one of
+// the following is reached: an all-zero entry, an entry whose title or
+// description pointer is outside the file, the end of the file, the area
+// table, or the first string an already read entry points to.
+func ReadMenu(br *BinaryReader) ([]MenuEntry, error) {
+	header, err := ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	size := br.Size()
+	if header.MenuOffset < HeaderSize || int64(header.MenuOffset) > size {
+		return nil, fmt.Errorf("menu pointer 0x%X at 0x00 is outside the file (size 0x%X)", header.MenuOffset, size)
+	}
+
+	limit := size
+	if header.AreaOffset >= header.MenuOffset && int64(header.AreaOffset) < limit {
+		limit = int64(header.AreaOffset)
+	}
+
+	var menuEntries []MenuEntry
+	for offset := int64(header.MenuOffset); offset+MenuEntrySize <= limit; offset += MenuEntrySize {
+		raw, err := br.ReadAt(offset, MenuEntrySize)
+		if err != nil {
+			return nil, fmt.Errorf("menu entry %d: %w", len(menuEntries), err)
+		}
+		if isZero(raw) {
+			break
+		}
+
+		titlePtr := binary.LittleEndian.Uint32(raw[48:])
+		descriptionPtr := binary.LittleEndian.Uint32(raw[52:])
+		if overlaps(titlePtr, header.MenuOffset, offset+MenuEntrySize) ||
+			overlaps(descriptionPtr, header.MenuOffset, offset+MenuEntrySize) {
+			// This "entry" is already string data.
+			break
+		}
+		if int64(titlePtr) >= size || int64(descriptionPtr) >= size {
+			// Nor is data whose "strings" are not in the file
+			break
+		}
+
+		if _, err := br.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to seek to menu entry at 0x%X: %w", offset, err)
+		}
+		entry, err := readMenuEntry(br)
+		if err != nil {
+			return nil, fmt.Errorf("menu entry %d: %w", len(menuEntries), err)
+		}
+		menuEntries = append(menuEntries, entry)
+
+		for _, ptr := range []uint32{titlePtr, descriptionPtr} {
+			if ptr > header.MenuOffset && int64(ptr) < limit {
+				limit = int64(ptr)
+			}
+		}
+	}
+	return menuEntries, nil
+}
+
+// overlaps reports whether ptr falls inside [start, end).
+func overlaps(ptr uint32, start uint32, end int64) bool {
+	return ptr >= start && int64(ptr) < end
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func readMenuEntry(br *BinaryReader) (MenuEntry, error) {
+	entry := MenuEntry{}
+	var err error
+
+	entry.JumpID, err = br.ReadUInt32()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read JumpID: %w", err)
+	}
+	entry.Unk0C, err = br.ReadUInt32()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read Unk0C: %w", err)
+	}
+	entry.AreaID, err = br.ReadUInt16()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read AreaID: %w", err)
+	}
+	entry.AreaID2, err = br.ReadUInt16()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read AreaID2: %w", err)
+	}
+	entry.AreaID3, err = br.ReadUInt16()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read AreaID3: %w", err)
+	}
+	entry.Unk18, err = br.ReadUInt16()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read Unk18: %w", err)
+	}
+	entry.PosX, err = br.ReadFloat32()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read PosX: %w", err)
+	}
+	entry.PosY, err = br.ReadFloat32()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read PosY: %w", err)
+	}
+	entry.PosZ, err = br.ReadFloat32()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read PosZ: %w", err)
+	}
+	entry.Rotation, err = br.ReadUInt32()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read Rotation: %w", err)
+	}
+	entry.PosX1, err = br.ReadFloat32()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read PosX1: %w", err)
+	}
+	entry.PosY1, err = br.ReadFloat32()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read PosY1: %w", err)
+	}
+	entry.PosZ1, err = br.ReadFloat32()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read PosZ1: %w", err)
+	}
+	entry.Rotation1, err = br.ReadUInt32()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read Rotation1: %w", err)
+	}
+
+	entry.Title, err = StringFromPointer(br)
+	if err != nil {
+		return entry, fmt.Errorf("failed to read Title: %w", err)
+	}
+	entry.Description, err = StringFromPointer(br)
+	if err != nil {
+		return entry, fmt.Errorf("failed to read Description: %w", err)
+	}
+	return entry, nil
+}
+
+// Limits on what a corrupt file can make the parser read. Areas may share
+// lists, so their total is not bounded by the file size: a few thousand area
+// headers pointing at the same long list would otherwise take gigabytes.
+const (
+	// maxAreaValues is the number of area entries and stage IDs read from
+	// all areas together.
+	maxAreaValues = 1 << 20
+	// maxStringSize is the longest menu text read, in bytes.
+	maxStringSize = 0x1000
+)
+
+// ReadAreas reads the AreaCount area headers stored at the pointer at 0x04
+// together with the entry and stage ID lists they point to. Every pointer is
+// checked against the file size before it is followed.
+func ReadAreas(br *BinaryReader) ([]Area, error) {
+	header, err := ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	size := br.Size()
+
+	tableEnd := int64(header.AreaOffset) + int64(header.AreaCount)*AreaHeaderSize
+	if header.AreaCount > 0 && tableEnd > size {
+		return nil, fmt.Errorf("area table at 0x%X with %d areas ends at 0x%X, past the end of the file (size 0x%X)",
+			header.AreaOffset, header.AreaCount, tableEnd, size)
+	}
+
+	var areas []Area
+	values := 0
+	for i := 0; i < int(header.AreaCount); i++ {
+		offset := int64(header.AreaOffset) + int64(i*AreaHeaderSize)
+		if _, err := br.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to seek to Area offset %d: %w", i, err)
+		}
+
+		pEntryData, err := br.ReadUInt32()
+		if err != nil {
+			return nil, fmt.Errorf("area %d: failed to read pEntryData: %w", i, err)
+		}
+		lenEntryData, err := br.ReadUInt32()
+		if err != nil {
+			return nil, fmt.Errorf("area %d: failed to read lenEntryData: %w", i, err)
+		}
+		pStageIds, err := br.ReadUInt32()
+		if err != nil {
+			return nil, fmt.Errorf("area %d: failed to read pStageIds: %w", i, err)
+		}
+
+		area := Area{}
+		if lenEntryData > 0 {
+			end := int64(pEntryData) + int64(lenEntryData)*AreaEntrySize
+			if pEntryData == 0 || end > size {
+				return nil, fmt.Errorf("area %d: %d entries at 0x%X do not fit in the file (size 0x%X): %w",
+					i, lenEntryData, pEntryData, size, ErrOutOfBounds)
+			}
+			values += int(lenEntryData)
+			if values > maxAreaValues {
+				return nil, fmt.Errorf("area %d: the areas hold more than %d entries and stage IDs", i, maxAreaValues)
+			}
+			raw, err := br.ReadAt(int64(pEntryData), int(lenEntryData)*AreaEntrySize)
+			if err != nil {
+				return nil, fmt.Errorf("area %d: failed to read entries: %w", i, err)
+			}
+			area.Entries = make([]AreaEntry, lenEntryData)
+			for j := range area.Entries {
+				area.Entries[j] = AreaEntry{
+					Index: binary.LittleEndian.Uint16(raw[j*AreaEntrySize:]),
+					Flags: binary.LittleEndian.Uint16(raw[j*AreaEntrySize+2:]),
+				}
+			}
+		}
+
+		// Stage IDs run until a 0
+		if pStageIds > 0 {
+			if int64(pStageIds)+2 > size {
+				return nil, fmt.Errorf("area %d: stage ID pointer 0x%X is outside the file (size 0x%X): %w",
+					i, pStageIds, size, ErrOutOfBounds)
+			}
+			if _, err := br.Seek(int64(pStageIds), io.SeekStart); err != nil {
+				return nil, fmt.Errorf("area %d: failed to seek to stage IDs: %w", i, err)
+			}
+			for {
+				id, err := br.ReadUInt16()
+				if err != nil {
+					return nil, fmt.Errorf("area %d: stage ID list at 0x%X is not terminated: %w", i, pStageIds, err)
+				}
+				if id == 0 {
+					break
+				}
+				if values++; values > maxAreaValues {
+					return nil, fmt.Errorf("area %d: the areas hold more than %d entries and stage IDs", i, maxAreaValues)
+				}
+				area.StageIDs = append(area.StageIDs, id)
+			}
+		}
+		areas = append(areas, area)
+	}
+	return areas, nil
+}
+
+// StringFromPointer reads a uint32 pointer at the current position and
+// returns the null-terminated Shift-JIS string it points to, leaving the
+// reader positioned just after the pointer. The string must end before the
+// end of the file and may not be longer than maxStringSize bytes.
+func StringFromPointer(br *BinaryReader) (string, error) {
+	offset, err := br.ReadPointer(1)
+	if err != nil {
+		return "", err
+	}
+	b, err := br.ReadCString(offset, maxStringSize)
+	if err != nil {
+		return "", err
+	}
+	return DecodeShiftJIS(b), nil
+}
@@ -0,0 +1,204 @@
+package jmp_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mhfjmp-editor/internal/fixture"
+	"mhfjmp-editor/jmp"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func reader(data []byte) *jmp.BinaryReader {
+	return jmp.NewBytesReader(data)
+}
+
+func TestReadMenu(t *testing.T) {
+	tests := []struct {
+		name  string
+		image fixture.Image
+		want  []jmp.MenuEntry
+	}{
+		{"default", fixture.Default(), fixture.Default().Menu},
+		{"menu right after header", fixture.Image{Menu: fixture.Default().Menu[:1]}, fixture.Default().Menu[:1]},
+		{"empty strings", fixture.Image{Menu: []jmp.MenuEntry{{JumpID: 1}}}, []jmp.MenuEntry{{JumpID: 1}}},
+		// Data after the table that does not point into the file ends it
+		{"data after the table", fixture.Image{Menu: fixture.Default().Menu[:1], AfterMenu: bytes.Repeat([]byte{0xFF}, jmp.MenuEntrySize)},
+			fixture.Default().Menu[:1]},
+		{"data pointing past the end", fixture.Image{Menu: fixture.Default().Menu[:2],
+			AfterMenu: append(make([]byte, jmp.MenuEntrySize-8), 1, 0, 0, 0, 0xFF, 0xFF, 0, 0)}, fixture.Default().Menu[:2]},
+		// The area table starts where the menu table would
+		{"no menu entries", fixture.Image{Areas: fixture.Default().Areas}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jmp.ReadMenu(reader(tt.image.Build()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadMenu() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Data between the menu table and the text is kept as a blob.
+func TestParseDataAfterMenu(t *testing.T) {
+	img := fixture.Default()
+	img.AfterMenu = bytes.Repeat([]byte{0xFF}, jmp.MenuEntrySize)
+	data := img.Build()
+	f := parse(t, data)
+	if !reflect.DeepEqual(f.Menu, img.Menu) || !reflect.DeepEqual(f.Areas, img.Areas) {
+		t.Errorf("Parse() =\n%+v\n%+v\nwant\n%+v\n%+v", f.Menu, f.Areas, img.Menu, img.Areas)
+	}
+	out, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("MarshalBinary() changed the file (%d bytes, want %d)", len(out), len(data))
+	}
+}
+
+func TestReadMenuErrors(t *testing.T) {
+	data := fixture.Default().Build()
+	binary.LittleEndian.PutUint32(data[0:], uint32(len(data)+4))
+	if _, err := jmp.ReadMenu(reader(data)); err == nil || !strings.Contains(err.Error(), "outside the file") {
+		t.Errorf("ReadMenu() with menu pointer past the end: err = %v", err)
+	}
+	if _, err := jmp.ReadMenu(reader(data[:8])); err == nil {
+		t.Error("ReadMenu() with a truncated header: no error")
+	}
+}
+
+func TestReadAreas(t *testing.T) {
+	tests := []struct {
+		name  string
+		image fixture.Image
+		want  []jmp.Area
+	}{
+		{"default", fixture.Default(), fixture.Default().Areas},
+		{"no areas", fixture.Image{Menu: fixture.Default().Menu}, nil},
+		{"only stage IDs", fixture.Image{Areas: []jmp.Area{{StageIDs: []uint16{0xFFFF, 1}}}}, []jmp.Area{{StageIDs: []uint16{0xFFFF, 1}}}},
+		{"empty area", fixture.Image{Areas: []jmp.Area{{}}}, []jmp.Area{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jmp.ReadAreas(reader(tt.image.Build()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadAreas() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAreasErrors(t *testing.T) {
+	img := fixture.Image{Areas: []jmp.Area{{Entries: []jmp.AreaEntry{{Index: 1, Flags: 2}}, StageIDs: []uint16{5}}}}
+	base := img.Build()
+	table := int(binary.LittleEndian.Uint32(base[4:]))
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+		want   string
+	}{
+		{"table past the end", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[8:], 100)
+			return b
+		}, "past the end of the file"},
+		{"entries past the end", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[table+4:], 1000)
+			return b
+		}, "do not fit in the file"},
+		{"null entry pointer", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[table:], 0)
+			return b
+		}, "do not fit in the file"},
+		{"stage IDs past the end", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[table+8:], uint32(len(b)))
+			return b
+		}, "outside the file"},
+		{"unterminated stage IDs", func(b []byte) []byte {
+			return b[:len(b)-2]
+		}, "not terminated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.modify(bytes.Clone(base))
+			_, err := jmp.ReadAreas(reader(data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ReadAreas() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// Areas sharing one list must not make the parser read it over and over.
+func TestReadAreasSharedLists(t *testing.T) {
+	const count, entries = 100, 20000
+	data := make([]byte, jmp.HeaderSize+count*jmp.AreaHeaderSize+entries*jmp.AreaEntrySize+2)
+	list := jmp.HeaderSize + count*jmp.AreaHeaderSize
+	binary.LittleEndian.PutUint32(data[0:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(data[4:], jmp.HeaderSize)
+	binary.LittleEndian.PutUint32(data[8:], count)
+	for i := 0; i < count; i++ {
+		header := data[jmp.HeaderSize+i*jmp.AreaHeaderSize:]
+		binary.LittleEndian.PutUint32(header[0:], uint32(list))
+		binary.LittleEndian.PutUint32(header[4:], entries)
+		binary.LittleEndian.PutUint32(header[8:], uint32(len(data)-2))
+	}
+	_, err := jmp.ReadAreas(reader(data))
+	if err == nil || !strings.Contains(err.Error(), "entries and stage IDs") {
+		t.Errorf("ReadAreas() error = %v, want the area value limit", err)
+	}
+}
+
+func TestStringFromPointer(t *testing.T) {
+	// Pointers at 0, 4, 8 and 12 to "ABC\0" at 16, Shift-JIS "広場\0" at 20,
+	// "\0" at 25 and an unterminated "XY" at 26
+	data := []byte{16, 0, 0, 0, 20, 0, 0, 0, 25, 0, 0, 0, 26, 0, 0, 0,
+		'A', 'B', 'C', 0, 0x8D, 0x4C, 0x8F, 0xEA, 0, 0, 'X', 'Y'}
+	tests := []struct {
+		name    string
+		data    []byte
+		pos     int64
+		want    string
+		wantErr bool
+	}{
+		{"ascii", data, 0, "ABC", false},
+		{"shift-jis", data, 4, "広場", false},
+		{"empty string", data, 8, "", false},
+		{"unterminated", data, 12, "", true},
+		{"pointer past the end", []byte{5, 0, 0, 0, 0}, 0, "", true},
+		{"no pointer", data[:4], 4, "", true},
+		{"too long", append([]byte{4, 0, 0, 0}, bytes.Repeat([]byte{'A'}, 0x1001)...), 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := reader(tt.data)
+			if _, err := br.Seek(tt.pos, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			got, err := jmp.StringFromPointer(br)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("StringFromPointer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("StringFromPointer() = %q, want %q", got, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			// The stream is left just after the pointer
+			if pos := br.Pos(); pos != tt.pos+4 {
+				t.Errorf("position after StringFromPointer() = %d, want %d", pos, tt.pos+4)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package jmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// ErrOutOfBounds is wrapped by every ReadError and PointerError for data or
+// a pointer target past the end of the file.
+var ErrOutOfBounds = errors.New("outside the file")
+
+// ReadError is a read that could not return all the bytes asked for.
+type ReadError struct {
+	Offset int64
+	Len    int
+	Size   int64 // size of the file
+	Err    error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("reading %d byte(s) at 0x%X: %v (file size 0x%X)", e.Len, e.Offset, e.Err, e.Size)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// PointerError is a pointer read at At whose target does not lie inside
+// the file.
+type PointerError struct {
+	At     int64
+	Target int64
+	Size   int64
+}
+
+func (e *PointerError) Error() string {
+	return fmt.Sprintf("pointer 0x%X at 0x%X is %v (size 0x%X)", e.Target, e.At, ErrOutOfBounds, e.Size)
+}
+
+func (e *PointerError) Unwrap() error {
+	return ErrOutOfBounds
+}
+
+// BinaryReader reads little-endian values from an mhfjmp.bin image held in
+// memory or behind an io.ReaderAt. A read either returns every byte asked
+// for or a *ReadError with the offset it failed at; it never returns zeros
+// for bytes past the end of the file.
+type BinaryReader struct {
+	r    io.ReaderAt
+	data []byte // set when reading from memory
+	size int64
+	pos  int64
+}
+
+// NewBinaryReader reads the first size bytes of r. *os.File and
+// *bytes.Reader are both an io.ReaderAt.
+func NewBinaryReader(r io.ReaderAt, size int64) *BinaryReader {
+	return &BinaryReader{r: r, size: size}
+}
+
+// NewBytesReader reads data.
+func NewBytesReader(data []byte) *BinaryReader {
+	return &BinaryReader{r: bytes.NewReader(data), data: data, size: int64(len(data))}
+}
+
+// OpenBinaryReader opens the file at path. The caller must Close it.
+func OpenBinaryReader(path string) (*BinaryReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return NewBinaryReader(file, info.Size()), nil
+}
+
+// Size returns the size of the file.
+func (br *BinaryReader) Size() int64 {
+	return br.size
+}
+
+// Pos returns the offset the next read starts at.
+func (br *BinaryReader) Pos() int64 {
+	return br.pos
+}
+
+// Seek implements io.Seeker. Unlike most seekers it does not allow moving
+// past the end of the file.
+func (br *BinaryReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += br.pos
+	case io.SeekEnd:
+		offset += br.size
+	}
+	if offset < 0 || offset > br.size {
+		return br.pos, &ReadError{Offset: offset, Size: br.size, Err: ErrOutOfBounds}
+	}
+	br.pos = offset
+	return offset, nil
+}
+
+// ReadAt returns the n bytes at offset without moving the position.
+// Bytes read from memory are not copied.
+func (br *BinaryReader) ReadAt(offset int64, n int) ([]byte, error) {
+	if n < 0 || offset < 0 || offset > br.size || int64(n) > br.size-offset {
+		return nil, &ReadError{Offset: offset, Len: n, Size: br.size, Err: ErrOutOfBounds}
+	}
+	if br.data != nil {
+		return br.data[offset : offset+int64(n) : offset+int64(n)], nil
+	}
+	b := make([]byte, n)
+	read, err := br.r.ReadAt(b, offset)
+	if read < n {
+		// The file is shorter than it was when the reader was made
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &ReadError{Offset: offset, Len: n, Size: br.size, Err: err}
+	}
+	return b, nil
+}
+
+// ReadBytes returns the next n bytes.
+func (br *BinaryReader) ReadBytes(n int) ([]byte, error) {
+	b, err := br.ReadAt(br.pos, n)
+	if err != nil {
+		return nil, err
+	}
+	br.pos += int64(n)
+	return b, nil
+}
+
+func (br *BinaryReader) ReadByte() (byte, error) {
+	b, err := br.ReadBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (br *BinaryReader) ReadUInt8() (uint8, error) {
+	return br.ReadByte()
+}
+
+func (br *BinaryReader) ReadInt16() (int16, error) {
+	v, err := br.ReadUInt16()
+	return int16(v), err
+}
+
+func (br *BinaryReader) ReadUInt16() (uint16, error) {
+	b, err := br.ReadBytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (br *BinaryReader) ReadInt32() (int32, error) {
+	v, err := br.ReadUInt32()
+	return int32(v), err
+}
+
+func (br *BinaryReader) ReadUInt32() (uint32, error) {
+	b, err := br.ReadBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (br *BinaryReader) ReadFloat32() (float32, error) {
+	v, err := br.ReadUInt32()
+	return math.Float32frombits(v), err
+}
+
+// ReadPointer reads a uint32 offset and checks that n bytes starting there
+// lie inside the file. n may be 0 for a pointer to the end of a list.
+func (br *BinaryReader) ReadPointer(n int64) (int64, error) {
+	at := br.pos
+	v, err := br.ReadUInt32()
+	if err != nil {
+		return 0, err
+	}
+	target := int64(v)
+	if target > br.size || n > br.size-target {
+		return 0, &PointerError{At: at, Target: target, Size: br.size}
+	}
+	return target, nil
+}
+
+// ReadCString returns the bytes at offset up to the next 0, without the 0
+// and without moving the position. A string longer than max bytes or not
+// terminated before the end of the file is an error.
+func (br *BinaryReader) ReadCString(offset int64, max int) ([]byte, error) {
+	if offset < 0 || offset >= br.size {
+		return nil, &ReadError{Offset: offset, Len: 1, Size: br.size, Err: ErrOutOfBounds}
+	}
+	n := int64(max) + 1
+	if n > br.size-offset {
+		n = br.size - offset
+	}
+	if br.data != nil {
+		if i := bytes.IndexByte(br.data[offset:offset+n], 0); i >= 0 {
+			return br.data[offset : offset+int64(i) : offset+int64(i)], nil
+		}
+	} else {
+		var out []byte
+		for chunk := int64(0); chunk < n; chunk += 256 {
+			b, err := br.ReadAt(offset+chunk, int(min(256, n-chunk)))
+			if err != nil {
+				return nil, err
+			}
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				return append(out, b[:i]...), nil
+			}
+			out = append(out, b...)
+		}
+	}
+	if offset+n == br.size && n <= int64(max) {
+		return nil, &ReadError{Offset: offset, Len: int(n) + 1, Size: br.size,
+			Err: fmt.Errorf("string is not terminated: %w", ErrOutOfBounds)}
+	}
+	return nil, fmt.Errorf("string at 0x%X is longer than %d bytes", offset, max)
+}
+
+// Close closes the underlying reader if it is closable.
+func (br *BinaryReader) Close() error {
+	if c, ok := br.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package jmp_test
+
+import (
+	"errors"
+	"io"
+	"mhfjmp-editor/jmp"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// shortReaderAt returns at most limit bytes per ReadAt and no error, which
+// io.ReaderAt does not allow but which BinaryReader must not trust.
+type shortReaderAt struct {
+	data  []byte
+	limit int
+}
+
+func (r shortReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := copy(p[:min(len(p), r.limit)], r.data[off:])
+	return n, nil
+}
+
+func TestBinaryReader(t *testing.T) {
+	data := []byte{0x01, 0x02, 0x03, 0x04, 0x08, 0x00, 0x00, 0x00, 'h', 'i', 0}
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	file, err := jmp.OpenBinaryReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	readers := map[string]*jmp.BinaryReader{
+		"bytes":    jmp.NewBytesReader(data),
+		"file":     file,
+		"ReaderAt": jmp.NewBinaryReader(shortReaderAt{data, len(data)}, int64(len(data))),
+	}
+	for name, br := range readers {
+		t.Run(name, func(t *testing.T) {
+			if v, err := br.ReadUInt32(); err != nil || v != 0x04030201 {
+				t.Errorf("ReadUInt32() = 0x%X, %v", v, err)
+			}
+			if ptr, err := br.ReadPointer(3); err != nil || ptr != 8 {
+				t.Errorf("ReadPointer(3) = %d, %v", ptr, err)
+			}
+			if s, err := br.ReadCString(8, 16); err != nil || string(s) != "hi" {
+				t.Errorf("ReadCString(8) = %q, %v", s, err)
+			}
+			if pos := br.Pos(); pos != 8 {
+				t.Errorf("Pos() = %d, want 8", pos)
+			}
+
+			// 3 bytes left
+			_, err := br.ReadUInt32()
+			var re *jmp.ReadError
+			if !errors.As(err, &re) || re.Offset != 8 || !errors.Is(err, jmp.ErrOutOfBounds) {
+				t.Errorf("ReadUInt32() at 8 error = %v, want a ReadError at 0x8", err)
+			}
+			if pos := br.Pos(); pos != 8 {
+				t.Errorf("Pos() after a failed read = %d, want 8", pos)
+			}
+
+			if _, err := br.Seek(4, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			var pe *jmp.PointerError
+			if _, err := br.ReadPointer(4); !errors.As(err, &pe) || pe.At != 4 || pe.Target != 8 {
+				t.Errorf("ReadPointer(4) error = %v, want a PointerError", err)
+			}
+			if _, err := br.Seek(1, io.SeekEnd); !errors.Is(err, jmp.ErrOutOfBounds) {
+				t.Errorf("Seek past the end error = %v", err)
+			}
+			if _, err := br.ReadCString(9, 16); err != nil {
+				t.Errorf("ReadCString(9) error = %v", err)
+			}
+			if _, err := br.ReadCString(0, 2); err == nil {
+				t.Error("ReadCString() longer than max: no error")
+			}
+		})
+	}
+}
+
+func TestBinaryReaderShortRead(t *testing.T) {
+	data := []byte{1, 2, 3, 4}
+	br := jmp.NewBinaryReader(shortReaderAt{data, 2}, int64(len(data)))
+	if _, err := br.ReadUInt32(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadUInt32() from a short ReaderAt error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
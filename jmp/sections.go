@@ -0,0 +1,214 @@
+package jmp
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// Blob is a byte range of the parsed image that is not part of any section
+// the parser understands: the bytes between the header and the menu table,
+// the empty entry that ends the menu, data after the area section, and so
+// on. Offset is where it was found. ModeRebuild writes every blob back in
+// its original order relative to the sections.
+type Blob struct {
+	Offset int
+	Data   []byte
+}
+
+type sectionKind int
+
+const (
+	sectionBlob sectionKind = iota
+	sectionHeader
+	sectionMenu
+	sectionText
+	sectionAreaTable
+	sectionAreaData
+)
+
+// section is a part of the image Marshal regenerates. size is its size in
+// the parsed image; for sectionText it includes the zero bytes following the
+// last string, which a rebuilt text pool may reuse. added marks a section
+// the parsed image did not have, which has no offset to keep.
+type section struct {
+	kind   sectionKind
+	offset int
+	size   int
+	data   []byte // sectionBlob only
+	added  bool
+}
+
+// sourceString is a title or description as the parsed image stores it.
+// size includes the terminating 0.
+type sourceString struct {
+	text   string
+	offset int
+	size   int
+}
+
+// scan splits the parsed image into sections and blobs. It uses the counts
+// and pointers of the image itself, so it must run before f.Menu or f.Areas
+// are edited. Where sections overlap the bytes belong to the first one
+// marked; only the first run of each kind is kept as a section, later runs
+// are regenerated together with it. The exception is text closer than one
+// entry after the menu table, which ends the table and so is kept over an
+// earlier run. Bytes between two strings that no entry points to, such as a
+// string left over from an earlier edit, belong to the text.
+func (f *File) scan() {
+	owner := make([]sectionKind, len(f.raw))
+	mark := func(kind sectionKind, start, end int) {
+		if start < 0 {
+			start = 0
+		}
+		if end > len(f.raw) {
+			end = len(f.raw)
+		}
+		for i := start; i < end; i++ {
+			if owner[i] == sectionBlob {
+				owner[i] = kind
+			}
+		}
+	}
+	u32 := func(offset int) int {
+		return int(binary.LittleEndian.Uint32(f.raw[offset:]))
+	}
+
+	mark(sectionHeader, 0, HeaderSize)
+	menu := int(f.Header.MenuOffset)
+	mark(sectionMenu, menu, menu+len(f.Menu)*MenuEntrySize)
+	var areaData [][2]int
+	if f.Header.AreaCount > 0 {
+		table := int(f.Header.AreaOffset)
+		mark(sectionAreaTable, table, table+int(f.Header.AreaCount)*AreaHeaderSize)
+		for i := 0; i < int(f.Header.AreaCount); i++ {
+			header := table + i*AreaHeaderSize
+			if n := u32(header + 4); n > 0 {
+				areaData = append(areaData, [2]int{u32(header), u32(header) + n*AreaEntrySize})
+			}
+			if p := u32(header + 8); p > 0 {
+				end := p
+				for end+2 <= len(f.raw) && binary.LittleEndian.Uint16(f.raw[end:]) != 0 {
+					end += 2
+				}
+				areaData = append(areaData, [2]int{p, end + 2})
+			}
+		}
+	}
+	for _, span := range areaData {
+		mark(sectionAreaData, span[0], span[1])
+	}
+	f.strings = make([]sourceString, 0, len(f.Menu)*2)
+	for i, entry := range f.Menu {
+		at := menu + i*MenuEntrySize
+		for j, text := range []string{entry.Title, entry.Description} {
+			ptr := u32(at + 48 + j*4)
+			end := ptr
+			for end < len(f.raw) && f.raw[end] != 0 {
+				end++
+			}
+			mark(sectionText, ptr, end+1)
+			f.strings = append(f.strings, sourceString{text: text, offset: ptr, size: end + 1 - ptr})
+		}
+	}
+	last := -1
+	for i, kind := range owner {
+		if kind != sectionText {
+			continue
+		}
+		if last >= 0 && i > last+1 && onlyBlob(owner[last+1:i]) {
+			for j := last + 1; j < i; j++ {
+				owner[j] = sectionText
+			}
+		}
+		last = i
+	}
+
+	f.sections = nil
+	f.Blobs = nil
+	seen := make(map[sectionKind]bool)
+	textEnd, text := -1, -1
+	menuEnd := menu + len(f.Menu)*MenuEntrySize
+	for start := 0; start < len(f.raw); {
+		kind := owner[start]
+		end := start + 1
+		for end < len(f.raw) && owner[end] == kind {
+			end++
+		}
+
+		switch {
+		case kind == sectionBlob:
+			if start == textEnd {
+				// Zero bytes after the text are free space of the pool
+				for start < end && f.raw[start] == 0 {
+					start++
+				}
+				f.sections[text].size = start - f.sections[text].offset
+			}
+			if start < end {
+				f.Blobs = append(f.Blobs, Blob{Offset: start, Data: f.raw[start:end]})
+			}
+		case !seen[kind]:
+			seen[kind] = true
+			f.sections = append(f.sections, section{kind: kind, offset: start, size: end - start})
+			if kind == sectionText {
+				textEnd, text = end, len(f.sections)-1
+			}
+		case kind == sectionText && f.sections[text].offset < menuEnd && start >= menuEnd && start < menuEnd+MenuEntrySize:
+			// The parser ends the menu table at this text, so it is the
+			// run the text is written to
+			f.sections[text] = section{kind: kind, offset: start, size: end - start}
+			textEnd = end
+		}
+		start = end
+	}
+}
+
+func onlyBlob(owner []sectionKind) bool {
+	for _, kind := range owner {
+		if kind != sectionBlob {
+			return false
+		}
+	}
+	return true
+}
+
+// rebuildOrder returns the sections and blobs, f.Blobs or fewer, in the
+// order of the parsed image. A section the image did not have, such as the
+// area table of a file without areas, is added right after the section that
+// precedes it in a file written from scratch, so that the menu table still
+// ends where the text or the area table starts. An empty menu table goes
+// right before the area table for the same reason, wherever the image had
+// it.
+func (f *File) rebuildOrder(blobs []Blob) []section {
+	order := append([]section(nil), f.sections...)
+	for _, b := range blobs {
+		order = append(order, section{kind: sectionBlob, offset: b.Offset, size: len(b.Data), data: b.Data})
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].offset < order[j].offset
+	})
+
+	// The header is always at 0x00, so an image without one was not parsed
+	prev := -1
+	for _, kind := range []sectionKind{sectionHeader, sectionMenu, sectionText, sectionAreaTable, sectionAreaData} {
+		at := -1
+		for i, s := range order {
+			if s.kind == kind {
+				at = i
+			}
+		}
+		if at < 0 {
+			at = prev + 1
+			if kind == sectionMenu {
+				for i, s := range order {
+					if s.kind == sectionAreaTable {
+						at = i
+					}
+				}
+			}
+			order = append(order[:at], append([]section{{kind: kind, added: true}}, order[at:]...)...)
+		}
+		prev = at
+	}
+	return order
+}
@@ -0,0 +1,201 @@
+package jmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+// DecodeShiftJIS converts Shift-JIS bytes to UTF-8. If conversion fails the
+// raw bytes are returned as is.
+func DecodeShiftJIS(b []byte) string {
+	utf8Bytes, err := japanese.ShiftJIS.NewDecoder().Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(utf8Bytes)
+}
+
+// ErrUnencodable is wrapped by every EncodeError.
+var ErrUnencodable = errors.New("not representable in Shift-JIS")
+
+// EncodeError is a character Shift-JIS cannot represent. Pos is the 1-based
+// character (not byte) position in the string.
+type EncodeError struct {
+	Rune rune
+	Pos  int
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("%U '%c' at character %d is %v", e.Rune, e.Rune, e.Pos, ErrUnencodable)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return ErrUnencodable
+}
+
+// EncodeShiftJIS converts str to Shift-JIS. If str contains a character
+// Shift-JIS cannot represent an *EncodeError for the first one is returned.
+func EncodeShiftJIS(str string) ([]byte, error) {
+	n, _, err := transform.Bytes(japanese.ShiftJIS.NewEncoder(), []byte(str))
+	if err != nil {
+		if bad := UnencodableRunes(str); len(bad) > 0 {
+			return nil, bad[0]
+		}
+		return nil, err
+	}
+	return n, nil
+}
+
+// UnencodableRunes returns every character of s that Shift-JIS cannot
+// represent.
+func UnencodableRunes(s string) []*EncodeError {
+	if _, _, err := transform.String(japanese.ShiftJIS.NewEncoder(), s); err == nil {
+		return nil
+	}
+	var bad []*EncodeError
+	pos := 0
+	for _, r := range s {
+		pos++
+		if !IsShiftJIS(r) {
+			bad = append(bad, &EncodeError{Rune: r, Pos: pos})
+		}
+	}
+	return bad
+}
+
+// IsShiftJIS reports whether r can be represented in Shift-JIS.
+func IsShiftJIS(r rune) bool {
+	_, _, err := transform.String(japanese.ShiftJIS.NewEncoder(), string(r))
+	return err == nil
+}
+
+// StringPool lays out null-terminated Shift-JIS strings starting at a base
+// offset in the output file. Identical strings are stored once; with suffix
+// sharing enabled a string that is the tail of a longer one points into it,
+// as long as it starts on a character of the longer one and not on the
+// second byte of a double-byte character.
+//
+// Strings are collected with Add, then Build places them; Offset is only
+// valid after Build.
+type StringPool struct {
+	base          uint32
+	shareSuffixes bool
+
+	strs    [][]byte // unique encoded strings, in first Add order
+	index   map[string]int
+	refs    int
+	refSize int
+
+	buf     []byte
+	offsets []uint32
+	shared  int
+}
+
+// PoolStats summarizes a built StringPool. Saved is the number of bytes
+// interning and suffix sharing saved over writing every string separately.
+type PoolStats struct {
+	Strings int
+	Unique  int
+	Shared  int
+	Size    int
+	Saved   int
+}
+
+func NewStringPool(base uint32, shareSuffixes bool) *StringPool {
+	return &StringPool{base: base, shareSuffixes: shareSuffixes, index: make(map[string]int)}
+}
+
+// Add registers s and returns a handle for Offset.
+func (p *StringPool) Add(s string) (int, error) {
+	encoded, err := EncodeShiftJIS(s)
+	if err != nil {
+		return 0, err
+	}
+	encoded = append(encoded, 0x00)
+	p.refs++
+	p.refSize += len(encoded)
+	if h, ok := p.index[string(encoded)]; ok {
+		return h, nil
+	}
+	p.index[string(encoded)] = len(p.strs)
+	p.strs = append(p.strs, encoded)
+	return len(p.strs) - 1, nil
+}
+
+// Build lays out the pool. Strings are written in the order they were first
+// added, except those stored as the suffix of another string.
+func (p *StringPool) Build() {
+	host := make([]int, len(p.strs))
+	for i := range host {
+		host[i] = -1
+	}
+	if p.shareSuffixes {
+		for i, s := range p.strs {
+			for j, t := range p.strs {
+				if len(t) > len(s) && bytes.HasSuffix(t, s) && charStart(t, len(t)-len(s)) &&
+					(host[i] < 0 || len(t) > len(p.strs[host[i]])) {
+					host[i] = j
+				}
+			}
+		}
+	}
+
+	p.buf = p.buf[:0]
+	p.offsets = make([]uint32, len(p.strs))
+	p.shared = 0
+	for i, s := range p.strs {
+		if host[i] >= 0 {
+			continue
+		}
+		p.offsets[i] = p.base + uint32(len(p.buf))
+		p.buf = append(p.buf, s...)
+	}
+	for i, s := range p.strs {
+		// The longest host of a suffix is never itself a suffix of another
+		// string, so it was placed above.
+		if h := host[i]; h >= 0 {
+			p.offsets[i] = p.offsets[h] + uint32(len(p.strs[h])-len(s))
+			p.shared++
+		}
+	}
+}
+
+// charStart reports whether a character of the Shift-JIS string b starts at
+// byte i.
+func charStart(b []byte, i int) bool {
+	j := 0
+	for j < i {
+		if c := b[j]; c >= 0x81 && c <= 0x9F || c >= 0xE0 && c <= 0xFC {
+			j += 2
+		} else {
+			j++
+		}
+	}
+	return j == i
+}
+
+func (p *StringPool) Offset(handle int) uint32 {
+	return p.offsets[handle]
+}
+
+func (p *StringPool) Bytes() []byte {
+	return p.buf
+}
+
+func (p *StringPool) Len() int {
+	return len(p.buf)
+}
+
+func (p *StringPool) Stats() PoolStats {
+	return PoolStats{
+		Strings: p.refs,
+		Unique:  len(p.strs),
+		Shared:  p.shared,
+		Size:    len(p.buf),
+		Saved:   p.refSize - len(p.buf),
+	}
+}
@@ -0,0 +1,152 @@
+package jmp
+
+import "fmt"
+
+// textLayout is the content Marshal writes to the text section and the
+// offset of every title and description in it, relative to the start of the
+// section: two per menu entry, title first.
+type textLayout struct {
+	buf     []byte
+	offsets []int
+	kept    int
+	stats   PoolStats
+}
+
+// layoutText places the titles and descriptions of f.Menu in the text
+// section s. When s comes from the parsed image, a string that is unchanged
+// since parsing keeps its offset in it, so an unedited text section comes out
+// as it was, with its repeated strings, string order and unreferenced bytes.
+// New and changed text identical to a kept string points at it. The rest goes
+// through the StringPool, which stores identical strings once and is placed
+// in the first gap large enough for it: zero bytes, or the bytes of a source
+// string no entry uses any more. If there is none it goes after the last byte
+// still in use. Source strings are never merged, even when identical.
+//
+// The parser finds the end of a menu table followed by text at the first
+// string, so if such text would no longer start with a string it is written
+// from scratch; with no entries left that drops it, bytes after an empty
+// table would be read as an entry. Text before the menu table keeps its
+// offsets either way.
+func (f *File) layoutText(s section, shareSuffixes bool) (textLayout, error) {
+	t, err := f.placeText(s, shareSuffixes, true)
+	if err != nil || !f.textAfterMenu(s) {
+		return t, err
+	}
+	for _, offset := range t.offsets {
+		if offset == 0 {
+			return t, nil
+		}
+	}
+	return f.placeText(s, shareSuffixes, false)
+}
+
+// textAfterMenu reports whether the text section s is written after the
+// menu table. A section the image did not have always is.
+func (f *File) textAfterMenu(s section) bool {
+	if s.added {
+		return true
+	}
+	for _, m := range f.sections {
+		if m.kind == sectionMenu {
+			return s.offset > m.offset
+		}
+	}
+	return true
+}
+
+func (f *File) placeText(s section, shareSuffixes, reuse bool) (textLayout, error) {
+	var t textLayout
+	if reuse && !s.added && s.size > 0 {
+		t.buf = append([]byte(nil), f.raw[s.offset:s.offset+s.size]...)
+	}
+	inside := func(str sourceString) bool {
+		return str.offset >= s.offset && str.offset+str.size <= s.offset+len(t.buf)
+	}
+	live := make([]bool, len(t.buf))
+	referenced := make([]bool, len(t.buf))
+	for _, str := range f.strings {
+		if inside(str) {
+			fill(referenced[str.offset-s.offset:str.offset-s.offset+str.size], true)
+		}
+	}
+
+	t.offsets = make([]int, len(f.Menu)*2)
+	texts := make([]string, 0, len(f.Menu)*2)
+	for _, entry := range f.Menu {
+		texts = append(texts, entry.Title, entry.Description)
+	}
+	kept := make(map[string]int)
+	var changed []int
+	for k, text := range texts {
+		if k >= len(f.strings) || f.strings[k].text != text || !inside(f.strings[k]) {
+			changed = append(changed, k)
+			continue
+		}
+		str := f.strings[k]
+		t.offsets[k] = str.offset - s.offset
+		fill(live[t.offsets[k]:t.offsets[k]+str.size], true)
+		if _, ok := kept[text]; !ok {
+			kept[text] = t.offsets[k]
+		}
+		t.kept++
+	}
+	// New text identical to a kept string points at it, the rest is
+	// interned in the pool
+	pool := NewStringPool(0, shareSuffixes)
+	handles := make(map[int]int)
+	for _, k := range changed {
+		if offset, ok := kept[texts[k]]; ok {
+			t.offsets[k] = offset
+			t.kept++
+			continue
+		}
+		h, err := pool.Add(texts[k])
+		if err != nil {
+			return t, fmt.Errorf("menu entry %d %s: %w", k/2, []string{"Title", "Description"}[k%2], err)
+		}
+		handles[k] = h
+	}
+	pool.Build()
+	t.stats = pool.Stats()
+
+	// Free the strings no entry uses any more
+	free := make([]bool, len(t.buf))
+	used := 0
+	for i, b := range t.buf {
+		free[i] = !live[i] && (referenced[i] || b == 0)
+		if free[i] {
+			t.buf[i] = 0
+		} else {
+			used = i + 1
+		}
+	}
+	if pool.Len() > 0 {
+		at, run := used, 0
+		for i := range free {
+			if !free[i] {
+				run = 0
+				continue
+			}
+			if run++; run == pool.Len() {
+				at = i + 1 - run
+				break
+			}
+		}
+		if end := at + pool.Len(); end > len(t.buf) {
+			t.buf = append(t.buf, make([]byte, end-len(t.buf))...)
+		}
+		copy(t.buf[at:], pool.Bytes())
+		used = max(used, at+pool.Len())
+		for k, h := range handles {
+			t.offsets[k] = at + int(pool.Offset(h))
+		}
+	}
+	t.buf = t.buf[:used]
+	return t, nil
+}
+
+func fill(b []bool, v bool) {
+	for i := range b {
+		b[i] = v
+	}
+}
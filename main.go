@@ -1,12 +1,38 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"mhfjmp-editor/container"
+	"mhfjmp-editor/diff"
 	"mhfjmp-editor/extractor"
 	"mhfjmp-editor/injector"
+	"mhfjmp-editor/jmp"
+	"mhfjmp-editor/names"
+	"mhfjmp-editor/patch"
+	"mhfjmp-editor/verify"
 	"os"
+	"path/filepath"
+	"strconv"
 )
 
+const usage = `Usage: mhfjmp-editor <command> [flags]
+
+Commands:
+  extract (e)   extract mhfjmp.bin to CSV or JSON
+  inject  (i)   build a patched mhfjmp.bin from CSV or JSON
+  lint    (l)   check the CSV files for mistakes before injecting them
+  migrate       update CSV files written by an older version
+  verify  (v)   check that extract and inject give back the same file
+  diff    (d)   list the menu and area changes between two mhfjmp.bin
+  apply   (a)   apply an IPS or BPS patch to mhfjmp.bin
+  init    (gf)  create the input and output folders
+
+Run 'mhfjmp-editor <command> -h' for the flags of a command.
+`
+
 func main() {
 	log.Println("Starting MHF data tool")
 
@@ -14,40 +40,329 @@ func main() {
 	if len(os.Args) >= 2 {
 		command = os.Args[1]
 	} else {
-		log.Fatalf("No command provided. Use 'extract(e)','inject(i)' or 'generate folders(gf)'")
+		fmt.Fprint(os.Stderr, usage)
+		log.Fatalf("No command provided, see the commands above")
 	}
 
 	log.Printf("Command received: '%s'. Processing...", command)
 
+	args := os.Args[2:]
 	switch command {
-	case "gf":
-		log.Println("Generating necessary folders for the program")
+	case "init", "gf":
+		runInit(args)
+	case "extract", "e":
+		runExtract(args)
+		log.Println("Data extraction done!")
+	case "inject", "i":
+		runInject(args)
+		log.Println("Data generation done!")
+	case "lint", "l":
+		runLint(args)
+	case "migrate":
+		runMigrate(args)
+	case "verify", "v":
+		runVerify(args)
+	case "diff", "d":
+		runDiff(args)
+	case "apply", "a":
+		runApply(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stderr, usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		log.Fatalf("Invalid command: '%s', see the commands above", command)
+	}
+}
+
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: mhfjmp-editor init [folder...]")
+		fmt.Fprintln(fs.Output(), "Creates the given folders, or 'input' and 'output' when none are given.")
+	}
+	fs.Parse(args)
+
+	log.Println("Generating necessary folders for the program")
 
-		// Define the necessary folders
-		folders := []string{
+	// Define the necessary folders
+	folders := fs.Args()
+	if len(folders) == 0 {
+		folders = []string{
 			"input",
 			"output",
 		}
+	}
 
-		// Create the folders if they do not exist
-		for _, folder := range folders {
-			if _, err := os.Stat(folder); os.IsNotExist(err) {
-				err := os.Mkdir(folder, os.ModePerm)
-				if err != nil {
-					log.Fatalf("Failed to create folder '%s': %v", folder, err)
-				}
-				log.Printf("Folder '%s' created successfully", folder)
-			} else {
-				log.Printf("Folder '%s' already exists", folder)
+	// Create the folders if they do not exist
+	for _, folder := range folders {
+		if _, err := os.Stat(folder); os.IsNotExist(err) {
+			err := os.MkdirAll(folder, os.ModePerm)
+			if err != nil {
+				log.Fatalf("Failed to create folder '%s': %v", folder, err)
 			}
+			log.Printf("Folder '%s' created successfully", folder)
+		} else {
+			log.Printf("Folder '%s' already exists", folder)
 		}
-	case "e":
-		extractor.ExtractData()
-		log.Println("Data extraction done!")
-	case "i":
-		injector.Start()
-		log.Println("Data generation done!")
-	default:
-		log.Fatalf("Invalid command: '%s'. Use 'extract' or 'generate'", command)
 	}
 }
+
+func runExtract(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	opts := extractor.Options{}
+	fs.StringVar(&opts.Input, "in", extractor.DefaultInput, "mhfjmp.bin to extract")
+	fs.StringVar(&opts.OutputDir, "dir", extractor.DefaultOutputDir, "folder the CSV or JSON files are written to")
+	fs.StringVar(&opts.Format, "format", "csv", "output format: 'csv', 'json' or both, as 'csv,json'")
+	fs.BoolVar(&opts.Hex, "hex", false, "write IDs, flags and stage IDs as 0x-prefixed hexadecimal (CSV only)")
+	namesPath := fs.String("names", "", "CSV file of 'Kind,ID,Name' rows naming areas and stages; adds read-only name columns for AreaIDs and stage IDs (CSV only)")
+	fs.Parse(args)
+
+	opts.Names = loadNames(*namesPath)
+
+	if err := extractor.Extract(opts); err != nil {
+		log.Fatalf("Extraction failed: %v", err)
+	}
+}
+
+// loadNames loads an ID/name mapping file, or returns nil if path is empty.
+func loadNames(path string) *names.Mapping {
+	if path == "" {
+		return nil
+	}
+	mapping, err := names.Load(path)
+	if err != nil {
+		log.Fatalf("Error loading name mapping: %v", err)
+	}
+	log.Printf("Loaded %d area and %d stage names from %s", mapping.Areas.Len(), mapping.Stages.Len(), path)
+	return mapping
+}
+
+func runInject(args []string) {
+	fs := flag.NewFlagSet("inject", flag.ExitOnError)
+	opts := injector.Options{}
+	fs.StringVar(&opts.Input, "in", injector.DefaultInput, "original mhfjmp.bin the patch is built from")
+	fs.StringVar(&opts.Dir, "dir", injector.DefaultDir, "folder the CSV or JSON files are read from")
+	fs.StringVar(&opts.Output, "out", injector.DefaultOutput, "patched mhfjmp.bin to write")
+	fs.StringVar(&opts.Format, "format", "csv", "input format: 'csv' or 'json'")
+	mode := fs.String("mode", "append", "output layout: 'append' keeps the original bytes and appends new tables, 'rebuild' writes a compact file")
+	fs.IntVar(&opts.StringAlign, "text-align", 4, "alignment of the section after the text pool")
+	fs.IntVar(&opts.StringPadding, "text-padding", 0, "zero bytes reserved after the last string")
+	fs.IntVar(&opts.StringLimit, "text-limit", 0, "fail if the text pool (with padding) is larger than this many bytes, 0 for no limit")
+	fs.BoolVar(&opts.ShareSuffixes, "share-suffixes", false, "store a string that ends another string inside it")
+	fs.BoolVar(&opts.Compact, "compact", false, "with -mode rebuild, drop the old tables earlier -mode append runs left in -in")
+	strict := fs.Bool("strict", true, "abort on any invalid CSV value instead of writing 0 and skipping short rows")
+	encoding := fs.String("encoding", "fail", "characters Shift-JIS cannot represent: 'fail', 'substitute' or 'strip'")
+	sjisMap := fs.String("sjis-map", "", "CSV file of 'character,replacement' pairs added to the built-in table for -encoding substitute")
+	namesPath := fs.String("names", "", "CSV file of 'Kind,ID,Name' rows naming areas and stages; AreaIDs and stage IDs may then be written as names")
+	fs.StringVar(&opts.IPS, "ips", "", "also write an IPS patch from -in to -out to this file")
+	fs.StringVar(&opts.BPS, "bps", "", "also write a BPS patch from -in to -out to this file")
+	fs.Parse(args)
+
+	var err error
+	if opts.Mode, err = jmp.ParseMode(*mode); err != nil {
+		log.Fatalf("Invalid -mode: %v", err)
+	}
+	opts.Lenient = !*strict
+	opts.Names = loadNames(*namesPath)
+	if opts.Encoding, err = injector.ParseEncodingPolicy(*encoding); err != nil {
+		log.Fatalf("Invalid -encoding: %v", err)
+	}
+	if *sjisMap != "" {
+		table, err := injector.LoadReplacements(*sjisMap)
+		if err != nil {
+			log.Fatalf("Error loading replacement table: %v", err)
+		}
+		opts.Replacements = make(map[rune]string)
+		for r, s := range injector.DefaultReplacements {
+			opts.Replacements[r] = s
+		}
+		for r, s := range table {
+			opts.Replacements[r] = s
+		}
+	}
+	report, err := injector.Inject(opts)
+	if err != nil {
+		log.Fatalf("Injection failed: %v", err)
+	}
+	fmt.Printf("✅ Injection completed in %s\n", report.Output)
+}
+
+func runLint(args []string) {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	opts := injector.Options{}
+	fs.StringVar(&opts.Dir, "dir", injector.DefaultDir, "folder the CSV files are read from")
+	namesPath := fs.String("names", "", "CSV file of 'Kind,ID,Name' rows, as given to inject")
+	fs.Parse(args)
+	opts.Names = loadNames(*namesPath)
+
+	findings, err := injector.Lint(opts)
+	if err != nil {
+		log.Fatalf("Lint failed: %v", err)
+	}
+	errors := 0
+	for _, f := range findings {
+		fmt.Println(f)
+		if f.Severity == injector.Error {
+			errors++
+		}
+	}
+	fmt.Printf("%d error(s), %d warning(s)\n", errors, len(findings)-errors)
+	if errors > 0 {
+		os.Exit(1)
+	}
+}
+
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dir := fs.String("dir", injector.DefaultDir, "folder the CSV files are in")
+	fs.Parse(args)
+
+	path := filepath.Join(*dir, "area_entries.csv")
+	notes, changed, err := injector.MigrateAreaCSV(path)
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+	for _, note := range notes {
+		log.Printf("Warning: %s", note)
+	}
+	if !changed {
+		fmt.Printf("%s is up to date\n", path)
+		return
+	}
+	fmt.Printf("✅ Removed the lenEntryData column from %s, the original is %s.bak\n", path, path)
+}
+
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	opts := verify.Options{}
+	fs.StringVar(&opts.Input, "in", extractor.DefaultInput, "mhfjmp.bin to check")
+	fs.StringVar(&opts.Format, "format", "csv", "intermediate format: 'csv' or 'json'")
+	mode := fs.String("mode", "rebuild", "injection mode: 'rebuild' is compared byte for byte, 'append' only structurally")
+	fs.IntVar(&opts.MaxRanges, "max", verify.DefaultMaxRanges, "number of differing byte ranges to list")
+	fs.Parse(args)
+
+	var err error
+	if opts.Mode, err = jmp.ParseMode(*mode); err != nil {
+		log.Fatalf("Invalid -mode: %v", err)
+	}
+	report, err := verify.Run(opts)
+	if err != nil {
+		log.Fatalf("Verification failed: %v", err)
+	}
+	report.WriteText(os.Stdout)
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: mhfjmp-editor diff [flags] old.bin new.bin")
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "text", "output format: 'text' or 'json'")
+	out := fs.String("out", "", "file to write the report to instead of standard output")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Invalid -format '%s', expected 'text' or 'json'", *format)
+	}
+
+	oldPath, newPath := fs.Arg(0), fs.Arg(1)
+	oldFile := parseFile(oldPath)
+	newFile := parseFile(newPath)
+	changes := diff.Files(oldFile, newFile)
+
+	var w io.WriteCloser = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Error creating %s: %v", *out, err)
+		}
+		w = file
+	}
+	switch *format {
+	case "text":
+		fmt.Fprintf(w, "--- %s\n+++ %s\n", oldPath, newPath)
+		diff.WriteText(w, changes)
+	case "json":
+		if err := diff.WriteJSON(w, diff.Result{Old: oldPath, New: newPath, Changes: changes}); err != nil {
+			log.Fatalf("Error writing JSON: %v", err)
+		}
+	}
+	if *out != "" {
+		if err := w.Close(); err != nil {
+			log.Fatalf("Error writing %s: %v", *out, err)
+		}
+	}
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+}
+
+func parseFile(path string) *jmp.File {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", path, err)
+	}
+	data, _, err = container.Unwrap(data)
+	if err != nil {
+		log.Fatalf("Error unwrapping %s: %v", path, err)
+	}
+	file, err := jmp.Parse(data)
+	if err != nil {
+		log.Fatalf("Error parsing %s: %v", path, err)
+	}
+	return file
+}
+
+func runApply(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	input := fs.String("in", injector.DefaultInput, "original mhfjmp.bin")
+	patchPath := fs.String("patch", "", "IPS or BPS patch to apply")
+	output := fs.String("out", injector.DefaultOutput, "patched mhfjmp.bin to write")
+	sourceCRC := fs.String("source-crc", "", "CRC32 the original must have, e.g. 0x1A2B3C4D (IPS only, BPS patches are always checked)")
+	fs.Parse(args)
+	if *patchPath == "" {
+		log.Fatalf("No patch given, use -patch")
+	}
+
+	var crc uint64
+	if *sourceCRC != "" {
+		var err error
+		if crc, err = strconv.ParseUint(*sourceCRC, 0, 32); err != nil {
+			log.Fatalf("Invalid -source-crc '%s': %v", *sourceCRC, err)
+		}
+	}
+	source, err := os.ReadFile(*input)
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", *input, err)
+	}
+	diff, err := os.ReadFile(*patchPath)
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", *patchPath, err)
+	}
+	format, err := patch.Detect(diff)
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", *patchPath, err)
+	}
+	if format == patch.IPS && crc == 0 {
+		log.Printf("Warning: IPS patches do not say which file they were made for, use -source-crc to check it")
+	}
+
+	target, err := patch.Apply(source, diff, uint32(crc))
+	if err != nil {
+		log.Fatalf("Error applying %s: %v", *patchPath, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(*output), os.ModePerm); err != nil {
+		log.Fatalf("Error creating output directory: %v", err)
+	}
+	if err := os.WriteFile(*output, target, 0644); err != nil {
+		log.Fatalf("Error writing %s: %v", *output, err)
+	}
+	fmt.Printf("✅ Patch applied, %s written (%d bytes)\n", *output, len(target))
+}
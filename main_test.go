@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"mhfjmp-editor/extractor"
+	"mhfjmp-editor/internal/fixture"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs main instead of the tests when the test binary is started by
+// runCommand, so that exit codes can be checked.
+func TestMain(m *testing.M) {
+	if os.Getenv("MHFJMP_EDITOR_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCommand runs the tool with args and returns its output and exit code.
+func runCommand(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "MHFJMP_EDITOR_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return string(out), exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), 0
+}
+
+func TestLintExitCode(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mhfjmp.bin")
+	if err := os.WriteFile(input, fixture.Default().Build(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractor.Extract(extractor.Options{Input: input, OutputDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code := runCommand(t, "lint", "-dir", dir)
+	if code != 0 || !strings.Contains(out, "0 error(s), 0 warning(s)") {
+		t.Errorf("lint on clean files exited with %d:\n%s", code, out)
+	}
+
+	// A warning alone does not fail
+	path := filepath.Join(dir, "menu_entries.csv")
+	data, _ := os.ReadFile(path)
+	edited := strings.Replace(string(data), ",1000,0,1,0,0,", ",1000,0,0,0,0,", 1)
+	if err := os.WriteFile(path, []byte(edited), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out, code = runCommand(t, "lint", "-dir", dir)
+	if code != 0 || !strings.Contains(out, "0 error(s), 1 warning(s)") {
+		t.Errorf("lint with a warning exited with %d:\n%s", code, out)
+	}
+
+	edited = strings.Replace(edited, ",1001,", ",1000,", 1)
+	if err := os.WriteFile(path, []byte(edited), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out, code = runCommand(t, "lint", "-dir", dir)
+	if code != 1 || !strings.Contains(out, "1 error(s), 1 warning(s)") {
+		t.Errorf("lint with a duplicate JumpID exited with %d, want 1:\n%s", code, out)
+	}
+}
+
+// A missing or unknown command is answered with the usage text, which lists
+// every command.
+func TestInvalidCommand(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "No command provided, see the commands above"},
+		{[]string{"export"}, "Invalid command: 'export', see the commands above"},
+	} {
+		out, code := runCommand(t, tt.args...)
+		if code == 0 || !strings.Contains(out, tt.want) || !strings.Contains(out, "apply   (a)") {
+			t.Errorf("%q exited with %d:\n%s", tt.args, code, out)
+		}
+	}
+}
+
+// An invalid -format is reported before -out is created or truncated.
+func TestDiffInvalidFormat(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mhfjmp.bin")
+	if err := os.WriteFile(input, fixture.Default().Build(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "report.txt")
+	if err := os.WriteFile(out, []byte("previous report"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	output, code := runCommand(t, "diff", "-format", "yaml", "-out", out, input, input)
+	if code == 0 || !strings.Contains(output, "Invalid -format 'yaml'") {
+		t.Errorf("diff -format yaml exited with %d:\n%s", code, output)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "previous report" {
+		t.Errorf("-out was overwritten with %q", data)
+	}
+}
@@ -0,0 +1,155 @@
+// Package names maps area and stage IDs to human-readable names, such as
+// stage 10 -> "Mezeporta Square", loaded from a user-supplied CSV file.
+package names
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mhfjmp-editor/internal/number"
+	"os"
+	"strings"
+)
+
+// Kind is the ID space a name belongs to.
+type Kind string
+
+const (
+	// Area is the menu AreaID, AreaID2 and AreaID3: a 1-based row of
+	// area_entries.csv.
+	Area Kind = "area"
+	// Stage is an ID of the StageIds lists.
+	Stage Kind = "stage"
+)
+
+// Mapping holds the names of both ID spaces. Area 1 and stage 1 are
+// unrelated, so each has its own Table and the same name may be used once
+// in each.
+type Mapping struct {
+	Areas  *Table
+	Stages *Table
+}
+
+// Table is a two-way ID/name mapping. Names are looked up case-insensitively.
+type Table struct {
+	byID   map[uint16]string
+	byName map[string]uint16
+}
+
+// Load reads a mapping file with one "Kind,ID,Name" row per line, Kind being
+// "area" or "stage". IDs may be decimal, 0x-prefixed hexadecimal or
+// 0b-prefixed binary. Lines starting with # are ignored.
+func Load(path string) (*Mapping, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The first record sets the number of columns of the others
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	m := &Mapping{Areas: newTable(), Stages: newTable()}
+	for first := true; ; first = false {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		line, _ := reader.FieldPos(0)
+		for i := range rec {
+			rec[i] = strings.TrimSpace(rec[i])
+		}
+		if first && strings.EqualFold(rec[0], "Kind") {
+			continue
+		}
+		if len(rec) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected Kind,ID,Name", path, line)
+		}
+		table := m.Table(Kind(strings.ToLower(rec[0])))
+		if table == nil {
+			return nil, fmt.Errorf("%s:%d: unknown kind '%s', expected '%s' or '%s'", path, line, rec[0], Area, Stage)
+		}
+		if err := table.add(rec[1], rec[2]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return m, nil
+}
+
+// Table returns the names of kind, or nil for an unknown kind or a nil
+// Mapping.
+func (m *Mapping) Table(kind Kind) *Table {
+	if m == nil {
+		return nil
+	}
+	switch kind {
+	case Area:
+		return m.Areas
+	case Stage:
+		return m.Stages
+	}
+	return nil
+}
+
+func newTable() *Table {
+	return &Table{byID: make(map[uint16]string), byName: make(map[string]uint16)}
+}
+
+func (t *Table) add(idText, name string) error {
+	id, err := parseID(idText)
+	if err != nil {
+		return fmt.Errorf("invalid ID '%s': %w", idText, err)
+	}
+	if name == "" {
+		return fmt.Errorf("ID %d has no name", id)
+	}
+	if _, err := parseID(name); err == nil {
+		return fmt.Errorf("name '%s' of ID %d is a number", name, id)
+	}
+	if strings.Contains(name, ",") {
+		return fmt.Errorf("name '%s' of ID %d contains a comma", name, id)
+	}
+	if prev, ok := t.byName[strings.ToLower(name)]; ok && prev != id {
+		return fmt.Errorf("name '%s' is used by both ID %d and ID %d", name, prev, id)
+	}
+	if prev, ok := t.byID[id]; ok && prev != name {
+		return fmt.Errorf("ID %d is named both '%s' and '%s'", id, prev, name)
+	}
+	t.byID[id] = name
+	t.byName[strings.ToLower(name)] = id
+	return nil
+}
+
+// Name returns the name of id.
+func (t *Table) Name(id uint16) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	name, ok := t.byID[id]
+	return name, ok
+}
+
+// ID returns the ID named name.
+func (t *Table) ID(name string) (uint16, bool) {
+	if t == nil {
+		return 0, false
+	}
+	id, ok := t.byName[strings.ToLower(strings.TrimSpace(name))]
+	return id, ok
+}
+
+// Len is the number of IDs in the table.
+func (t *Table) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.byID)
+}
+
+func parseID(s string) (uint16, error) {
+	v, err := number.ParseUint(s, 16)
+	return uint16(v), err
+}
@@ -0,0 +1,73 @@
+package names
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func load(t *testing.T, content string) (*Mapping, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "names.csv")
+	if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoad(t *testing.T) {
+	m, err := load(t, "# comment\nKind,ID,Name\narea,1,Mezeporta\nstage,1,Forest and Hills\nStage, 0x65 , Desert\nstage,0b110,Swamp\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		kind Kind
+		id   uint16
+		name string
+	}{
+		{Area, 1, "Mezeporta"},
+		{Stage, 1, "Forest and Hills"},
+		{Stage, 0x65, "Desert"},
+		{Stage, 6, "Swamp"},
+	}
+	for _, tt := range tests {
+		if name, ok := m.Table(tt.kind).Name(tt.id); !ok || name != tt.name {
+			t.Errorf("%s %d is named %q, %v, want %q", tt.kind, tt.id, name, ok, tt.name)
+		}
+		if id, ok := m.Table(tt.kind).ID(strings.ToUpper(tt.name)); !ok || id != tt.id {
+			t.Errorf("%s %q has ID %d, %v, want %d", tt.kind, tt.name, id, ok, tt.id)
+		}
+	}
+	if _, ok := m.Areas.ID("Desert"); ok {
+		t.Error("a stage name is found among the areas")
+	}
+	if m.Areas.Len() != 1 || m.Stages.Len() != 3 {
+		t.Errorf("Len() = %d areas and %d stages, want 1 and 3", m.Areas.Len(), m.Stages.Len())
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"unknown kind", "quest,1,Hunt\n", "unknown kind"},
+		{"bad ID", "area,x,Name\n", "invalid ID"},
+		{"numeric name", "area,1,2\n", "is a number"},
+		{"duplicate name", "area,1,Square\narea,2,square\n", "used by both"},
+		{"renamed ID", "stage,1,A\nstage,1,B\n", "named both"},
+		{"column count", "area,1,A\n2,B\n", "wrong number of fields"},
+		{"ID,Name pairs", "ID,Name\n100,Town Gate\n", "expected Kind,ID,Name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := load(t, tt.content); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+	// The same name may be an area and a stage
+	if _, err := load(t, "area,1,Square\nstage,1,Square\n"); err != nil {
+		t.Errorf("Load() with an area and a stage of the same name: %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+const bpsMagic = "BPS1"
+
+// BPS actions, stored in the low 2 bits of each command.
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+// bpsMinCopy is the shortest match worth a SourceCopy command.
+const bpsMinCopy = 4
+
+// CreateBPS builds a BPS patch. Bytes that are unchanged in place become
+// SourceRead commands, runs found elsewhere in source SourceCopy commands
+// and everything else TargetRead literals.
+func CreateBPS(source, target []byte) []byte {
+	var out bytes.Buffer
+	out.WriteString(bpsMagic)
+	writeVarint(&out, uint64(len(source)))
+	writeVarint(&out, uint64(len(target)))
+	writeVarint(&out, 0) // no metadata
+
+	// Index every bpsMinCopy bytes of source by their content
+	index := make(map[string]int)
+	for i := 0; i+bpsMinCopy <= len(source); i++ {
+		key := string(source[i : i+bpsMinCopy])
+		if _, ok := index[key]; !ok {
+			index[key] = i
+		}
+	}
+
+	sourceRelative := 0
+	literal := -1
+	flush := func(end int) {
+		if literal >= 0 {
+			writeVarint(&out, uint64(end-literal-1)<<2|bpsTargetRead)
+			out.Write(target[literal:end])
+			literal = -1
+		}
+	}
+	for pos := 0; pos < len(target); {
+		same := 0
+		for pos+same < len(target) && pos+same < len(source) && source[pos+same] == target[pos+same] {
+			same++
+		}
+		if same >= bpsMinCopy || (same > 0 && pos+same == len(target)) {
+			flush(pos)
+			writeVarint(&out, uint64(same-1)<<2|bpsSourceRead)
+			pos += same
+			continue
+		}
+
+		if pos+bpsMinCopy <= len(target) {
+			if from, ok := index[string(target[pos:pos+bpsMinCopy])]; ok {
+				n := 0
+				for from+n < len(source) && pos+n < len(target) && source[from+n] == target[pos+n] {
+					n++
+				}
+				flush(pos)
+				writeVarint(&out, uint64(n-1)<<2|bpsSourceCopy)
+				writeSigned(&out, from-sourceRelative)
+				sourceRelative = from + n
+				pos += n
+				continue
+			}
+		}
+
+		if literal < 0 {
+			literal = pos
+		}
+		pos++
+	}
+	flush(len(target))
+
+	var crc [4]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(source))
+	out.Write(crc[:])
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(target))
+	out.Write(crc[:])
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(out.Bytes()))
+	out.Write(crc[:])
+	return out.Bytes()
+}
+
+// ApplyBPS applies a BPS patch after checking the patch and source
+// checksums, and checks the checksum of the result.
+func ApplyBPS(source, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, []byte(bpsMagic)) || len(patch) < len(bpsMagic)+12 {
+		return nil, fmt.Errorf("%w: not a BPS patch", ErrFormat)
+	}
+	footer := patch[len(patch)-12:]
+	if crc := crc32.ChecksumIEEE(patch[:len(patch)-4]); crc != binary.LittleEndian.Uint32(footer[8:]) {
+		return nil, fmt.Errorf("%w: patch is damaged (CRC32 0x%08X, expected 0x%08X)",
+			ErrChecksum, crc, binary.LittleEndian.Uint32(footer[8:]))
+	}
+	if crc := crc32.ChecksumIEEE(source); crc != binary.LittleEndian.Uint32(footer[0:]) {
+		return nil, fmt.Errorf("%w: source CRC32 is 0x%08X, the patch expects 0x%08X",
+			ErrChecksum, crc, binary.LittleEndian.Uint32(footer[0:]))
+	}
+
+	r := &bpsReader{data: patch[:len(patch)-12], pos: len(bpsMagic)}
+	sourceSize := r.varint()
+	targetSize := r.varint()
+	metadataSize := r.varint()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if sourceSize != uint64(len(source)) {
+		return nil, fmt.Errorf("%w: source is %d bytes, the patch expects %d", ErrChecksum, len(source), sourceSize)
+	}
+	if metadataSize > uint64(len(r.data)-r.pos) {
+		return nil, fmt.Errorf("%w: header is out of range", ErrFormat)
+	}
+	if targetSize > MaxSize {
+		return nil, fmt.Errorf("%w: target size %d is larger than %d", ErrFormat, targetSize, MaxSize)
+	}
+	r.pos += int(metadataSize)
+
+	target := make([]byte, 0, targetSize)
+	sourceRelative, targetRelative := 0, 0
+	for r.pos < len(r.data) {
+		cmd := r.varint()
+		length := int(cmd>>2) + 1
+		if r.err != nil || uint64(len(target)+length) > targetSize {
+			return nil, fmt.Errorf("%w: command at 0x%X writes past the target size", ErrFormat, r.pos)
+		}
+		switch cmd & 3 {
+		case bpsSourceRead:
+			if len(target)+length > len(source) {
+				return nil, fmt.Errorf("%w: SourceRead past the end of the source", ErrFormat)
+			}
+			target = append(target, source[len(target):len(target)+length]...)
+		case bpsTargetRead:
+			if r.pos+length > len(r.data) {
+				return nil, fmt.Errorf("%w: TargetRead past the end of the patch", ErrFormat)
+			}
+			target = append(target, r.data[r.pos:r.pos+length]...)
+			r.pos += length
+		case bpsSourceCopy:
+			sourceRelative += r.signed()
+			if r.err != nil || sourceRelative < 0 || sourceRelative+length > len(source) {
+				return nil, fmt.Errorf("%w: SourceCopy outside the source", ErrFormat)
+			}
+			target = append(target, source[sourceRelative:sourceRelative+length]...)
+			sourceRelative += length
+		case bpsTargetCopy:
+			targetRelative += r.signed()
+			if r.err != nil || targetRelative < 0 || targetRelative >= len(target) {
+				return nil, fmt.Errorf("%w: TargetCopy outside the target", ErrFormat)
+			}
+			// The copy may overlap the bytes it writes
+			for i := 0; i < length; i++ {
+				target = append(target, target[targetRelative])
+				targetRelative++
+			}
+		}
+	}
+	if uint64(len(target)) != targetSize {
+		return nil, fmt.Errorf("%w: target is %d bytes, the patch expects %d", ErrFormat, len(target), targetSize)
+	}
+	if crc := crc32.ChecksumIEEE(target); crc != binary.LittleEndian.Uint32(footer[4:]) {
+		return nil, fmt.Errorf("%w: result CRC32 is 0x%08X, the patch expects 0x%08X",
+			ErrChecksum, crc, binary.LittleEndian.Uint32(footer[4:]))
+	}
+	return target, nil
+}
+
+// writeVarint writes the BPS variable length encoding of v.
+func writeVarint(out *bytes.Buffer, v uint64) {
+	for {
+		x := byte(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			out.WriteByte(0x80 | x)
+			return
+		}
+		out.WriteByte(x)
+		v--
+	}
+}
+
+// writeSigned writes a relative offset: the magnitude shifted left by one,
+// with the sign in the low bit.
+func writeSigned(out *bytes.Buffer, v int) {
+	if v < 0 {
+		writeVarint(out, uint64(-v)<<1|1)
+		return
+	}
+	writeVarint(out, uint64(v)<<1)
+}
+
+type bpsReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *bpsReader) varint() uint64 {
+	var v uint64
+	shift := uint64(1)
+	for {
+		if r.pos >= len(r.data) || shift > 1<<56 {
+			r.err = fmt.Errorf("%w: number at 0x%X is cut short", ErrFormat, r.pos)
+			return 0
+		}
+		x := r.data[r.pos]
+		r.pos++
+		v += uint64(x&0x7F) * shift
+		if x&0x80 != 0 {
+			return v
+		}
+		shift <<= 7
+		v += shift
+	}
+}
+
+func (r *bpsReader) signed() int {
+	v := r.varint()
+	if v&1 != 0 {
+		return -int(v >> 1)
+	}
+	return int(v >> 1)
+}
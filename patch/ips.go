@@ -0,0 +1,156 @@
+package patch
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	ipsMagic = "PATCH"
+	ipsEOF   = "EOF"
+	// ipsMaxOffset is the first offset a 3 byte record offset cannot hold.
+	ipsMaxOffset = 1 << 24
+	ipsMaxRecord = 0xFFFF
+	// ipsEOFOffset reads as "EOF" and cannot start a record.
+	ipsEOFOffset = 0x454F46
+	// ipsMinRLE is the shortest run of one byte worth an RLE record.
+	ipsMinRLE = 9
+)
+
+// CreateIPS builds an IPS patch. When target is shorter than source the
+// patch ends with the common truncation extension.
+func CreateIPS(source, target []byte) ([]byte, error) {
+	if len(target) > ipsMaxOffset {
+		return nil, fmt.Errorf("target is %d bytes, IPS cannot address more than %d", len(target), ipsMaxOffset)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(ipsMagic)
+	for i := 0; i < len(target); {
+		if i < len(source) && source[i] == target[i] {
+			i++
+			continue
+		}
+		// Extend the change over short equal stretches, a new record costs
+		// 5 bytes
+		start, end := i, i+1
+		for end < len(target) && end-start < ipsMaxRecord {
+			if end >= len(source) || source[end] != target[end] {
+				end++
+				continue
+			}
+			same := end
+			for same < len(target) && same < len(source) && source[same] == target[same] && same-end < 6 {
+				same++
+			}
+			if same-end >= 6 || same == len(target) {
+				break
+			}
+			end = same
+		}
+		if start == ipsEOFOffset {
+			start--
+		}
+		if end-start > ipsMaxRecord {
+			end = start + ipsMaxRecord
+		}
+		writeIPSRecords(&out, start, target[start:end])
+		i = end
+	}
+	out.WriteString(ipsEOF)
+	if len(target) < len(source) {
+		out.Write([]byte{byte(len(target) >> 16), byte(len(target) >> 8), byte(len(target))})
+	}
+	return out.Bytes(), nil
+}
+
+// writeIPSRecords writes data at offset, using RLE records for long runs of
+// one byte.
+func writeIPSRecords(out *bytes.Buffer, offset int, data []byte) {
+	header := func(offset, size int) {
+		out.Write([]byte{byte(offset >> 16), byte(offset >> 8), byte(offset), byte(size >> 8), byte(size)})
+	}
+	literal := 0
+	for i := 0; i < len(data); {
+		run := 1
+		for i+run < len(data) && data[i+run] == data[i] {
+			run++
+		}
+		if run >= ipsMinRLE && offset+i+run == ipsEOFOffset {
+			// Leave the next record a valid offset
+			run--
+		}
+		if run < ipsMinRLE || offset+i == ipsEOFOffset {
+			i += run
+			continue
+		}
+		if literal < i {
+			header(offset+literal, i-literal)
+			out.Write(data[literal:i])
+		}
+		header(offset+i, 0)
+		out.Write([]byte{byte(run >> 8), byte(run), data[i]})
+		i += run
+		literal = i
+	}
+	if literal < len(data) {
+		header(offset+literal, len(data)-literal)
+		out.Write(data[literal:])
+	}
+}
+
+// ApplyIPS applies an IPS patch, including the truncation extension.
+func ApplyIPS(source, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, []byte(ipsMagic)) {
+		return nil, fmt.Errorf("%w: missing %q header", ErrFormat, ipsMagic)
+	}
+	target := append([]byte(nil), source...)
+	pos := len(ipsMagic)
+	for {
+		if pos+3 > len(patch) {
+			return nil, fmt.Errorf("%w: missing %q marker", ErrFormat, ipsEOF)
+		}
+		if string(patch[pos:pos+3]) == ipsEOF {
+			pos += 3
+			break
+		}
+		if pos+5 > len(patch) {
+			return nil, fmt.Errorf("%w: record at 0x%X is cut short", ErrFormat, pos)
+		}
+		offset := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		size := int(patch[pos+3])<<8 | int(patch[pos+4])
+		pos += 5
+
+		var data []byte
+		if size == 0 {
+			if pos+3 > len(patch) {
+				return nil, fmt.Errorf("%w: RLE record at 0x%X is cut short", ErrFormat, pos-5)
+			}
+			run := int(patch[pos])<<8 | int(patch[pos+1])
+			data = bytes.Repeat([]byte{patch[pos+2]}, run)
+			pos += 3
+		} else {
+			if pos+size > len(patch) {
+				return nil, fmt.Errorf("%w: record at 0x%X is cut short", ErrFormat, pos-5)
+			}
+			data = patch[pos : pos+size]
+			pos += size
+		}
+		if end := offset + len(data); end > len(target) {
+			target = append(target, make([]byte, end-len(target))...)
+		}
+		copy(target[offset:], data)
+	}
+
+	switch len(patch) - pos {
+	case 0:
+	case 3:
+		size := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		if size < len(target) {
+			target = target[:size]
+		}
+	default:
+		return nil, fmt.Errorf("%w: %d unexpected bytes after %q", ErrFormat, len(patch)-pos, ipsEOF)
+	}
+	return target, nil
+}
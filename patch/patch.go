@@ -0,0 +1,72 @@
+// Package patch creates and applies IPS and BPS patches, so a patched
+// mhfjmp.bin can be distributed without the editor or the CSV files.
+package patch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+var (
+	// ErrFormat is a patch that is not a valid IPS or BPS file.
+	ErrFormat = errors.New("invalid patch")
+	// ErrChecksum is a source, target or patch that does not match the
+	// checksum the patch expects.
+	ErrChecksum = errors.New("checksum mismatch")
+)
+
+// MaxSize is the largest BPS target size accepted, so that a corrupt header
+// cannot make Apply allocate gigabytes.
+const MaxSize = 64 << 20
+
+// Format is the file format of a patch.
+type Format string
+
+const (
+	IPS Format = "ips"
+	BPS Format = "bps"
+)
+
+// Detect returns the format of a patch from its magic bytes.
+func Detect(patch []byte) (Format, error) {
+	switch {
+	case bytes.HasPrefix(patch, []byte(ipsMagic)):
+		return IPS, nil
+	case bytes.HasPrefix(patch, []byte(bpsMagic)):
+		return BPS, nil
+	}
+	return "", fmt.Errorf("%w: unknown magic, expected %q or %q", ErrFormat, ipsMagic, bpsMagic)
+}
+
+// Create builds a patch turning source into target.
+func Create(format Format, source, target []byte) ([]byte, error) {
+	switch format {
+	case IPS:
+		return CreateIPS(source, target)
+	case BPS:
+		return CreateBPS(source, target), nil
+	}
+	return nil, fmt.Errorf("unknown patch format '%s', expected 'ips' or 'bps'", format)
+}
+
+// Apply applies an IPS or BPS patch to source. BPS patches carry the
+// checksums of their source and target and are always verified. IPS patches
+// carry none; sourceCRC, if not 0, is checked against the CRC32 of source
+// instead.
+func Apply(source, patch []byte, sourceCRC uint32) ([]byte, error) {
+	format, err := Detect(patch)
+	if err != nil {
+		return nil, err
+	}
+	if format == BPS {
+		return ApplyBPS(source, patch)
+	}
+	if sourceCRC != 0 {
+		if crc := crc32.ChecksumIEEE(source); crc != sourceCRC {
+			return nil, fmt.Errorf("%w: source CRC32 is 0x%08X, the patch expects 0x%08X", ErrChecksum, crc, sourceCRC)
+		}
+	}
+	return ApplyIPS(source, patch)
+}
@@ -0,0 +1,205 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"testing"
+)
+
+// pair is a source and the target a patch should turn it into.
+type pair struct{ source, target []byte }
+
+func testPairs() map[string]pair {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	r.Read(random)
+	edited := append([]byte(nil), random...)
+	copy(edited[100:], "メゼポルタ広場")
+	edited[4000] ^= 0xFF
+	moved := append(append([]byte(nil), random[2500:]...), random[:2500]...)
+	runs := append(append([]byte(nil), random...), bytes.Repeat([]byte{0xAB}, 1000)...)
+	copy(runs[1000:], bytes.Repeat([]byte{0}, 300))
+
+	// Changes at the offset that reads as "EOF", and a run ending there
+	large := make([]byte, ipsEOFOffset+64)
+	atEOF := append([]byte(nil), large...)
+	atEOF[ipsEOFOffset] = 1
+	runToEOF := append([]byte(nil), large...)
+	copy(runToEOF[ipsEOFOffset-20:], bytes.Repeat([]byte{0x11}, 30))
+
+	return map[string]pair{
+		"unchanged":         {random, random},
+		"edited":            {random, edited},
+		"moved":             {random, moved},
+		"grown":             {random, append(append([]byte(nil), random...), "TRAILER"...)},
+		"truncated":         {random, random[:3000]},
+		"emptied":           {random, nil},
+		"empty source":      {nil, random},
+		"runs":              {random, runs},
+		"EOF offset":        {large, atEOF},
+		"run to EOF offset": {large, runToEOF},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []Format{IPS, BPS} {
+		for name, p := range testPairs() {
+			t.Run(string(format)+"/"+name, func(t *testing.T) {
+				patch, err := Create(format, p.source, p.target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err := Detect(patch); got != format || err != nil {
+					t.Errorf("Detect() = %q, %v, want %q", got, err, format)
+				}
+				got, err := Apply(p.source, patch, crc32.ChecksumIEEE(p.source))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, p.target) {
+					t.Errorf("Apply(Create()) differs from the target (%d bytes, want %d)", len(got), len(p.target))
+				}
+			})
+		}
+	}
+}
+
+// No IPS record may start at the offset that reads as "EOF", or the patch
+// would end there.
+func TestCreateIPSEOFOffset(t *testing.T) {
+	for _, name := range []string{"EOF offset", "run to EOF offset"} {
+		p := testPairs()[name]
+		patch, err := CreateIPS(p.source, p.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i := bytes.Index(patch, []byte(ipsEOF)); i != len(patch)-len(ipsEOF) {
+			t.Errorf("%s: %q at 0x%X of a %d byte patch", name, ipsEOF, i, len(patch))
+		}
+	}
+}
+
+func TestCreateIPSRLE(t *testing.T) {
+	source := make([]byte, 100)
+	target := append(bytes.Repeat([]byte{0xAB}, 50), make([]byte, 50)...)
+	patch, err := CreateIPS(source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("PATCH\x00\x00\x00\x00\x00\x00\x32\xABEOF")
+	if !bytes.Equal(patch, want) {
+		t.Errorf("CreateIPS() = %q, want %q", patch, want)
+	}
+}
+
+func TestCreateIPSTooLarge(t *testing.T) {
+	if _, err := CreateIPS(nil, make([]byte, ipsMaxOffset+1)); err == nil {
+		t.Error("CreateIPS() of a target IPS cannot address succeeded")
+	}
+}
+
+func TestApplyIPS(t *testing.T) {
+	source := []byte("0123456789")
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"record", "PATCH\x00\x00\x02\x00\x03abcEOF", "01abc56789"},
+		{"RLE", "PATCH\x00\x00\x01\x00\x00\x00\x04zEOF", "0zzzz56789"},
+		{"past the end", "PATCH\x00\x00\x0C\x00\x02abEOF", "0123456789\x00\x00ab"},
+		{"truncated", "PATCH\x00\x00\x00\x00\x01xEOF\x00\x00\x04", "x123"},
+		{"truncation longer than the file", "PATCH\x00\x00\x00\x00\x01xEOF\x00\x01\x00", "x123456789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyIPS(source, []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ApplyIPS() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyIPSErrors(t *testing.T) {
+	tests := map[string]string{
+		"no header":      "PATCX\x00\x00\x00\x00\x01xEOF",
+		"no EOF":         "PATCH\x00\x00\x00\x00\x01x",
+		"cut record":     "PATCH\x00\x00\x00\x00\x05xy",
+		"cut header":     "PATCH\x00\x00\x00\x00",
+		"cut RLE record": "PATCH\x00\x00\x00\x00\x00\x00",
+		"trailing bytes": "PATCH\x00\x00\x00\x00\x01xEOF\x00\x01",
+	}
+	for name, patch := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ApplyIPS([]byte("0123456789"), []byte(patch)); !errors.Is(err, ErrFormat) {
+				t.Errorf("ApplyIPS() error = %v, want ErrFormat", err)
+			}
+		})
+	}
+}
+
+// seal replaces the patch checksum at the end of a BPS patch.
+func seal(patch []byte) []byte {
+	binary.LittleEndian.PutUint32(patch[len(patch)-4:], crc32.ChecksumIEEE(patch[:len(patch)-4]))
+	return patch
+}
+
+func TestApplyBPSErrors(t *testing.T) {
+	p := testPairs()["edited"]
+	valid := CreateBPS(p.source, p.target)
+	mutate := func(f func(patch []byte) []byte) []byte {
+		return f(append([]byte(nil), valid...))
+	}
+
+	var oversized bytes.Buffer
+	oversized.WriteString(bpsMagic)
+	writeVarint(&oversized, uint64(len(p.source)))
+	writeVarint(&oversized, MaxSize+1)
+	writeVarint(&oversized, 0)
+	oversized.Write(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(p.source)))
+	oversized.Write(make([]byte, 8))
+
+	tests := []struct {
+		name   string
+		source []byte
+		patch  []byte
+		want   error
+	}{
+		{"damaged patch", p.source, mutate(func(b []byte) []byte { b[20] ^= 1; return b }), ErrChecksum},
+		{"wrong source", p.target, valid, ErrChecksum},
+		{"wrong target checksum", p.source, mutate(func(b []byte) []byte { b[len(b)-8] ^= 1; return seal(b) }), ErrChecksum},
+		{"too short", p.source, []byte(bpsMagic), ErrFormat},
+		{"oversized target", p.source, seal(oversized.Bytes()), ErrFormat},
+		{"cut command", p.source, mutate(func(b []byte) []byte {
+			return seal(append(b[:len(b)-12], append([]byte{0x00}, b[len(b)-12:]...)...))
+		}), ErrFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ApplyBPS(tt.source, tt.patch); !errors.Is(err, tt.want) {
+				t.Errorf("ApplyBPS() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	p := testPairs()["edited"]
+	ips, err := CreateIPS(p.source, p.target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Apply(p.source, ips, crc32.ChecksumIEEE(p.target)); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Apply() of an IPS patch to the wrong source: error = %v, want ErrChecksum", err)
+	}
+	if _, err := Apply(p.source, []byte("UPS1"), 0); !errors.Is(err, ErrFormat) {
+		t.Errorf("Apply() of an unknown format: error = %v, want ErrFormat", err)
+	}
+}
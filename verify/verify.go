@@ -0,0 +1,220 @@
+// Package verify checks that an mhfjmp.bin survives an extract and inject
+// cycle unchanged.
+package verify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mhfjmp-editor/container"
+	"mhfjmp-editor/diff"
+	"mhfjmp-editor/extractor"
+	"mhfjmp-editor/injector"
+	"mhfjmp-editor/jmp"
+	"os"
+	"path/filepath"
+)
+
+// DefaultMaxRanges is the number of differing byte ranges listed when
+// Options.MaxRanges is 0.
+const DefaultMaxRanges = 10
+
+type Options struct {
+	// Input is the mhfjmp.bin to check. Empty means extractor.DefaultInput.
+	Input string
+	// Format is the format of the intermediate files, "csv" or "json".
+	// Empty means "csv".
+	Format string
+	// Mode is the injection mode. Only ModeRebuild is expected to give back
+	// the same bytes, ModeAppend is only compared structurally.
+	Mode jmp.Mode
+	// MaxRanges limits the number of differing byte ranges in the report.
+	MaxRanges int
+}
+
+// Range is a run of differing bytes. Field names the part of the original
+// file it starts in.
+type Range struct {
+	Offset int
+	Length int
+	Field  string
+}
+
+// Report is the result of Run. Structural lists the differences between the
+// parsed original and the parsed output; Ranges the first differing byte
+// ranges, out of DiffBytes differing bytes. For an ECD or JKR wrapped input
+// Layers lists the layers and the sizes and bytes are those of the files
+// inside them.
+type Report struct {
+	Input      string
+	Mode       jmp.Mode
+	Layers     []container.Layer
+	Size       int
+	OutputSize int
+	Structural []string
+	Ranges     []Range
+	DiffBytes  int
+}
+
+// OK reports whether the round trip was lossless.
+func (r *Report) OK() bool {
+	if len(r.Structural) > 0 {
+		return false
+	}
+	return r.Mode != jmp.ModeRebuild || (r.DiffBytes == 0 && r.Size == r.OutputSize)
+}
+
+// Run extracts opts.Input to a temporary folder, injects it back exactly as
+// the inject command would and compares the result with the original. A
+// missing input is reported as jmp.ErrMissingInput.
+func Run(opts Options) (*Report, error) {
+	if opts.Input == "" {
+		opts.Input = extractor.DefaultInput
+	}
+	if opts.MaxRanges == 0 {
+		opts.MaxRanges = DefaultMaxRanges
+	}
+
+	data, err := os.ReadFile(opts.Input)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %w", jmp.ErrMissingInput, err)
+		}
+		return nil, err
+	}
+	data, layers, err := container.Unwrap(data)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping %s: %w", opts.Input, err)
+	}
+	original, err := jmp.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", opts.Input, err)
+	}
+
+	dir, err := os.MkdirTemp("", "mhfjmp-verify-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := extractor.Extract(extractor.Options{Input: opts.Input, OutputDir: dir, Format: opts.Format}); err != nil {
+		return nil, err
+	}
+	output := filepath.Join(dir, "mhfjmp.bin")
+	injectOpts := injector.Options{Input: opts.Input, Dir: dir, Output: output, Format: opts.Format}
+	injectOpts.Mode = opts.Mode
+	if _, err := injector.Inject(injectOpts); err != nil {
+		return nil, err
+	}
+	rebuilt, err := os.ReadFile(output)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Input: opts.Input, Mode: opts.Mode, Layers: layers, Size: len(data)}
+	// Compressing again need not give back the same bytes, so the files
+	// inside the layers are compared
+	rebuilt, outputLayers, err := container.Unwrap(rebuilt)
+	if err != nil {
+		report.Structural = append(report.Structural, fmt.Sprintf("output does not unwrap: %v", err))
+		return report, nil
+	}
+	if got, want := container.Describe(outputLayers), container.Describe(layers); got != want {
+		report.Structural = append(report.Structural, fmt.Sprintf("output layers are %s, want %s", got, want))
+	}
+	report.OutputSize = len(rebuilt)
+	parsed, err := jmp.Parse(rebuilt)
+	if err != nil {
+		report.Structural = append(report.Structural, fmt.Sprintf("output does not parse: %v", err))
+		return report, nil
+	}
+	report.Structural = compareFiles(original, parsed, opts.Mode == jmp.ModeRebuild)
+	if opts.Mode == jmp.ModeRebuild {
+		report.Ranges, report.DiffBytes = compareBytes(original, data, rebuilt, opts.MaxRanges)
+	}
+	return report, nil
+}
+
+// compareFiles lists every menu entry field, area entry, stage ID list and,
+// if withBlobs is set, unknown block that differs between a and b.
+func compareFiles(a, b *jmp.File, withBlobs bool) []string {
+	var diffs []string
+	for _, c := range diff.Files(a, b) {
+		diffs = append(diffs, c.String())
+	}
+
+	if withBlobs {
+		if len(a.Blobs) != len(b.Blobs) {
+			diffs = append(diffs, fmt.Sprintf("unknown blocks: %d, got %d", len(a.Blobs), len(b.Blobs)))
+		}
+		for i := 0; i < len(a.Blobs) && i < len(b.Blobs); i++ {
+			if !bytes.Equal(a.Blobs[i].Data, b.Blobs[i].Data) {
+				diffs = append(diffs, fmt.Sprintf("unknown block at 0x%X (%d bytes) differs from the one at 0x%X (%d bytes)",
+					a.Blobs[i].Offset, len(a.Blobs[i].Data), b.Blobs[i].Offset, len(b.Blobs[i].Data)))
+			}
+		}
+	}
+	return diffs
+}
+
+// compareBytes returns the first max runs of differing bytes and the total
+// number of differing bytes. Bytes past the end of the shorter image count
+// as differing.
+func compareBytes(original *jmp.File, a, b []byte, max int) ([]Range, int) {
+	var ranges []Range
+	total := 0
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	for i := 0; i < n; {
+		if i < len(a) && i < len(b) && a[i] == b[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < n && !(i < len(a) && i < len(b) && a[i] == b[i]) {
+			i++
+		}
+		total += i - start
+		if len(ranges) < max {
+			ranges = append(ranges, Range{Offset: start, Length: i - start, Field: original.FieldAt(start)})
+		}
+	}
+	return ranges, total
+}
+
+// WriteText prints the report for a terminal.
+func (r *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "%s: %d bytes, round trip (%s) wrote %d bytes\n", r.Input, r.Size, r.Mode, r.OutputSize)
+	if len(r.Layers) > 0 {
+		fmt.Fprintf(w, "Layers: %s, sizes are of the file inside\n", container.Describe(r.Layers))
+	}
+	if len(r.Structural) == 0 {
+		fmt.Fprintln(w, "Structure: identical")
+	} else {
+		fmt.Fprintf(w, "Structure: %d difference(s)\n", len(r.Structural))
+		for _, d := range r.Structural {
+			fmt.Fprintf(w, "  %s\n", d)
+		}
+	}
+
+	if r.Mode != jmp.ModeRebuild {
+		fmt.Fprintf(w, "Bytes: not compared, %s mode moves the tables\n", r.Mode)
+	} else if r.DiffBytes == 0 && r.Size == r.OutputSize {
+		fmt.Fprintln(w, "Bytes: identical")
+	} else {
+		fmt.Fprintf(w, "Bytes: %d differing byte(s), first %d range(s):\n", r.DiffBytes, len(r.Ranges))
+		for _, rg := range r.Ranges {
+			fmt.Fprintf(w, "  0x%06X +%d: %s\n", rg.Offset, rg.Length, rg.Field)
+		}
+	}
+
+	if r.OK() {
+		fmt.Fprintln(w, "✅ Round trip is lossless")
+	} else {
+		fmt.Fprintln(w, "❌ Round trip changed the file")
+	}
+}
@@ -0,0 +1,100 @@
+package verify
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mhfjmp-editor/container"
+	"mhfjmp-editor/internal/fixture"
+	"mhfjmp-editor/jmp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func writeFile(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mhfjmp.bin")
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	for name, img := range fixture.Variants() {
+		for _, mode := range []jmp.Mode{jmp.ModeRebuild, jmp.ModeAppend} {
+			for _, format := range []string{"csv", "json"} {
+				t.Run(name+"/"+mode.String()+"/"+format, func(t *testing.T) {
+					input := writeFile(t, img.Build())
+					report, err := Run(Options{Input: input, Mode: mode, Format: format})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !report.OK() {
+						var sb strings.Builder
+						report.WriteText(&sb)
+						t.Errorf("Run() reports a lossy round trip:\n%s", sb.String())
+					}
+				})
+			}
+		}
+	}
+}
+
+// An appended file has its menu 17 bytes past the end of the original, which
+// rebuild must leave where it is.
+func TestRunAppended(t *testing.T) {
+	for name, img := range fixture.Variants() {
+		t.Run(name, func(t *testing.T) {
+			f, err := jmp.Parse(img.Build())
+			if err != nil {
+				t.Fatal(err)
+			}
+			appended, _, err := f.Marshal(jmp.MarshalOptions{Mode: jmp.ModeAppend})
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err := Run(Options{Input: writeFile(t, appended)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.OK() {
+				var sb strings.Builder
+				report.WriteText(&sb)
+				t.Errorf("Run() reports a lossy round trip:\n%s", sb.String())
+			}
+		})
+	}
+}
+
+func TestRunWrapped(t *testing.T) {
+	layers := []container.Layer{{Kind: container.ECD, Key: 4}, {Kind: container.JKR, Type: container.JKRLZ}}
+	wrapped, err := container.Wrap(fixture.Default().Build(), layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Run(Options{Input: writeFile(t, wrapped)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Errorf("Run() reports a lossy round trip: %v", report.Structural)
+	}
+	if got, want := container.Describe(report.Layers), container.Describe(layers); got != want {
+		t.Errorf("Run() found layers %s, want %s", got, want)
+	}
+}
+
+func TestRunMissingInput(t *testing.T) {
+	_, err := Run(Options{Input: filepath.Join(t.TempDir(), "missing.bin")})
+	if !errors.Is(err, jmp.ErrMissingInput) {
+		t.Errorf("Run() error = %v, want ErrMissingInput", err)
+	}
+}